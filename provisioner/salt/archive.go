@@ -0,0 +1,239 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// ----------------------------------------------------------------------------
+// Archive upload methods
+// ----------------------------------------------------------------------------
+
+// dirFiles returns the files within a local directory that are not excluded by
+// the rules, with their slash separated paths relative to the directory.
+// Symbolic links are followed.
+func dirFiles(dir string, rules ignoreRules) ([]slsFile, error) {
+	var files []slsFile
+	visiting := make(map[string]bool)
+
+	var walk func(source string, rel string) error
+	walk = func(source string, rel string) error {
+		real, err := filepath.EvalSymlinks(source)
+		if err != nil {
+			return err
+		}
+		if visiting[real] {
+			return fmt.Errorf("%s is a symbolic link loop", source)
+		}
+		visiting[real] = true
+		defer delete(visiting, real)
+
+		entries, err := os.ReadDir(source)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			childSource := filepath.Join(source, e.Name())
+			childRel := path.Join(rel, e.Name())
+			info, err := os.Stat(childSource)
+			if err != nil {
+				return err
+			}
+			if rules.excluded(childRel, info.IsDir()) {
+				continue
+			}
+			if info.IsDir() {
+				if err := walk(childSource, childRel); err != nil {
+					return err
+				}
+			} else if info.Mode().IsRegular() {
+				files = append(files, slsFile{Source: childSource, Path: childRel})
+			}
+		}
+		return nil
+	}
+
+	return files, walk(dir, "")
+}
+
+// canExtract reports whether archives can be extracted on the target system.
+// The check is only run once.
+func (p *Provisioner) canExtract(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator) bool {
+	if p.extractor == nil {
+		_, exitStatus, err := p.runCommandWithOutput(ctx, comm, p.formatCommand("cmdCheckExtract"))
+		available := err == nil && exitStatus == 0
+		if !available {
+			ui.Say("No archive extractor is available on the target system, files are uploaded separately")
+		}
+		p.extractor = &available
+	}
+	return *p.extractor
+}
+
+// uploadArchive packs the files into a single archive, uploads it and extracts
+// it into targetDir. It returns false without uploading anything if archives
+// cannot be extracted on the target system. The time saved is only estimated
+// when the files would otherwise have been uploaded separately, rather than as
+// a directory.
+func (p *Provisioner) uploadArchive(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, targetDir string, files []slsFile, separate bool) (bool, error) {
+	if len(files) == 0 || !p.canExtract(ctx, ui, comm) {
+		return false, nil
+	}
+	start := time.Now()
+
+	ext := ".tar.gz"
+	writeArchive := writeTarGz
+	if p.config.TargetOS == "windows" {
+		ext = ".zip"
+		writeArchive = writeZip
+	}
+
+	tmp, err := os.CreateTemp("", "packer-salt-*"+ext)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := writeArchive(tmp, files)
+	if err != nil {
+		return false, fmt.Errorf("error creating archive: %s", err)
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	dir := filepath.ToSlash(targetDir)
+	roundTripStart := time.Now()
+	if err := p.createDir(ctx, ui, comm, dir); err != nil {
+		return false, err
+	}
+	roundTrip := time.Since(roundTripStart)
+	remoteArchive := path.Clean(dir) + ext
+	ui.Say(fmt.Sprintf("Uploading %d files to %s as an archive", len(files), dir))
+	if err := comm.Upload(remoteArchive, tmp, nil); err != nil {
+		return false, err
+	}
+
+	cmd := &packersdk.RemoteCmd{Command: p.formatCommand("cmdExtract", dir, remoteArchive)}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		return false, err
+	}
+	if cmd.ExitStatus() != 0 {
+		return false, fmt.Errorf("non-zero exit status while extracting archive %s", remoteArchive)
+	}
+
+	elapsed := time.Since(start)
+	if !separate {
+		ui.Say(fmt.Sprintf("Uploaded %d bytes as a %d byte archive in %s, saving %d bytes",
+			size, info.Size(), elapsed.Round(time.Millisecond), size-info.Size()))
+		return true, nil
+	}
+	ui.Say(fmt.Sprintf("Uploaded %d bytes as a %d byte archive in %s, saving %d bytes, %d separate uploads and an estimated %s",
+		size, info.Size(), elapsed.Round(time.Millisecond), size-info.Size(), len(files)-1,
+		estimateTimeSaved(len(files), roundTrip, elapsed).Round(time.Millisecond)))
+	return true, nil
+}
+
+// estimateTimeSaved estimates how much faster an archive upload was than
+// uploading the files separately. Each file uploaded separately needs a command
+// to create its directory and an upload, which each take at least the round trip
+// time of the command that created the target directory. The time taken to
+// transfer the contents of the files is not counted, so the estimate is low.
+func estimateTimeSaved(files int, roundTrip time.Duration, elapsed time.Duration) time.Duration {
+	saved := time.Duration(files)*2*roundTrip - elapsed
+	if saved < 0 {
+		return 0
+	}
+	return saved
+}
+
+// writeTarGz writes the files to w as a gzip compressed tar archive, and
+// returns the total size of the files.
+func writeTarGz(w io.Writer, files []slsFile) (int64, error) {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	var size int64
+	for _, f := range files {
+		n, err := addArchiveFile(f, func(info os.FileInfo) (io.Writer, error) {
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return nil, err
+			}
+			hdr.Name = f.Path
+			return tw, tw.WriteHeader(hdr)
+		})
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return size, gw.Close()
+}
+
+// writeZip writes the files to w as a zip archive, and returns the total size
+// of the files.
+func writeZip(w io.Writer, files []slsFile) (int64, error) {
+	zw := zip.NewWriter(w)
+
+	var size int64
+	for _, f := range files {
+		n, err := addArchiveFile(f, func(info os.FileInfo) (io.Writer, error) {
+			hdr, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return nil, err
+			}
+			hdr.Name = f.Path
+			hdr.Method = zip.Deflate
+			return zw.CreateHeader(hdr)
+		})
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+
+	return size, zw.Close()
+}
+
+// addArchiveFile copies a file into an archive, using create to add the
+// header for the file.
+func addArchiveFile(f slsFile, create func(os.FileInfo) (io.Writer, error)) (int64, error) {
+	src, err := os.Open(f.Source)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	w, err := create(info)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, src)
+}
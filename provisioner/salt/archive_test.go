@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestUploadArchiveSummary(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"top.sls":      "base:\n  '*':\n    - web\n",
+		"web/init.sls": "nginx:\n  pkg.installed\n",
+	})
+	files, err := dirFiles(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		separate bool
+		want     bool
+	}{
+		{name: "files uploaded separately", separate: true, want: true},
+		{name: "directory upload", separate: false, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			ui := &packersdk.BasicUi{Reader: new(bytes.Buffer), Writer: &out, ErrorWriter: &out}
+			p := &Provisioner{}
+			p.config.TargetOS = "linux"
+
+			uploaded, err := p.uploadArchive(context.Background(), ui, new(packersdk.MockCommunicator), "/tmp/states", files, tt.separate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !uploaded {
+				t.Fatal("archive was not uploaded")
+			}
+			if !strings.Contains(out.String(), "byte archive") {
+				t.Fatalf("no archive summary in output:\n%s", out.String())
+			}
+			if got := strings.Contains(out.String(), "estimated"); got != tt.want {
+				t.Errorf("time saved estimated = %t; want %t, output:\n%s", got, tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestEstimateTimeSaved(t *testing.T) {
+	if got := estimateTimeSaved(10, 100*time.Millisecond, 500*time.Millisecond); got != 1500*time.Millisecond {
+		t.Errorf("estimateTimeSaved = %s; want 1.5s", got)
+	}
+	if got := estimateTimeSaved(1, 100*time.Millisecond, time.Second); got != 0 {
+		t.Errorf("estimateTimeSaved = %s; want 0", got)
+	}
+}
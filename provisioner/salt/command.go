@@ -0,0 +1,102 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// saltCallTemplate is the data available to the execute_command template.
+type saltCallTemplate struct {
+	Sudo      string
+	Vars      string
+	SaltCall  string
+	LogLevel  string
+	LogFile   string
+	StateDir  string
+	PillarDir string
+	ExtraArgs string
+	Target    string
+}
+
+// sudoPlaceholder marks where sudo is used by the commands in saltCommandMap,
+// matching the Sudo field of the execute_command template.
+const sudoPlaceholder = "{{.Sudo}}"
+
+// ----------------------------------------------------------------------------
+// salt-call command methods
+// ----------------------------------------------------------------------------
+
+// sudoCommand returns the prefix used to run a command with sudo on Linux,
+// which is empty when sudo is prevented.
+func (p *Provisioner) sudoCommand() string {
+	if p.config.TargetOS == "windows" || p.config.PreventSudo {
+		return ""
+	}
+	if p.config.SudoPassword != "" {
+		return fmt.Sprintf("echo '%s' | sudo -S ", escapeSecret(p.config.SudoPassword))
+	}
+	return "sudo "
+}
+
+// withSudo replaces the sudo placeholder in a formatted command.
+func (p *Provisioner) withSudo(command string) string {
+	return strings.ReplaceAll(command, sudoPlaceholder, p.sudoCommand())
+}
+
+// formatCommand returns a command for the target OS formatted with its
+// arguments, with sudo used where the command requires it.
+func (p *Provisioner) formatCommand(valueName string, args ...interface{}) string {
+	command := p.getCommand(valueName)
+	if len(args) > 0 {
+		command = fmt.Sprintf(command, args...)
+	}
+	return p.withSudo(command)
+}
+
+// saltCallCommand returns the salt-call command that runs a Salt function with
+// its arguments, rendered from execute_command or the default command.
+func (p *Provisioner) saltCallCommand(envVars string, logFile string, function string) (string, error) {
+	// Select the args based on whether a minion configuration or pillar data is present
+	var extraArgs []string
+	if p.configDir != "" {
+		extraArgs = append(extraArgs, "--config-dir="+p.configDir)
+	} else {
+		extraArgs = append(extraArgs, "--file-root="+p.config.StateDir)
+		if p.usesPillarDir() {
+			extraArgs = append(extraArgs, "--pillar-root="+p.config.PillarDir)
+		}
+	}
+
+	rawCommand := p.config.ExecuteCommand
+	if rawCommand == "" {
+		rawCommand = saltCommandMap["cmdSaltCall_"+p.config.TargetOS]
+	}
+
+	p.config.ctx.Data = &saltCallTemplate{
+		Sudo:      p.sudoCommand(),
+		Vars:      envVars,
+		SaltCall:  p.config.SaltCallPath,
+		LogLevel:  p.config.LogLevel,
+		LogFile:   logFile,
+		StateDir:  p.config.StateDir,
+		PillarDir: p.config.PillarDir,
+		ExtraArgs: strings.Join(extraArgs, " "),
+		Target:    function,
+	}
+	command, err := interpolate.Render(rawCommand, &p.config.ctx)
+	if err != nil {
+		return "", fmt.Errorf("error rendering execute_command: %s", err)
+	}
+	// cmd.exe removes the first and last quotes of a command that starts with a
+	// quote and contains more than two, so the default command is wrapped in an
+	// extra pair of quotes when salt-call is run directly with quoted arguments
+	if p.config.ExecuteCommand == "" && p.config.TargetOS == "windows" && strings.HasPrefix(command, `"`) && strings.Count(command, `"`) > 2 {
+		command = `"` + command + `"`
+	}
+	return command, nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// powerShellEnvWrapper runs the command passed to the PowerShell wrapper script.
+// Windows PowerShell does not escape the double quotes within the arguments of
+// a native command, so each argument is quoted for the command line here.
+const powerShellEnvWrapper = `$command, $arguments = $args
+$command = (Get-Command $command -CommandType Application -ErrorAction Stop | Select-Object -First 1).Path
+$arguments = foreach ($a in $arguments) {
+  if ($a -ne '' -and $a -notmatch '[\s"]') { $a; continue }
+  '"' + ($a -replace '(\\*)"', '$1$1\"' -replace '(\\+)$', '$1$1') + '"'
+}
+$process = Start-Process -FilePath $command -ArgumentList $arguments -NoNewWindow -Wait -PassThru
+exit $process.ExitCode
+`
+
+// ----------------------------------------------------------------------------
+// Environment file methods
+// ----------------------------------------------------------------------------
+
+// uploadEnvFile writes the environment variables to a wrapper script and
+// restricts access to it. The wrapper exports the variables before running the
+// command passed to it, so the values never appear on the salt-call command
+// line. The script is uploaded into a directory that only the connecting user
+// and administrators can access, so that it is never readable by other users.
+func (p *Provisioner) uploadEnvFile(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator) (string, error) {
+	envDir := filepath.ToSlash(p.getConfig("configEnvDir"))
+	envFile := path.Join(envDir, p.getConfig("configEnvFile"))
+
+	var content string
+	if p.config.TargetOS == "windows" {
+		content = p.createPowerShellEnvFile()
+	} else {
+		content = p.createShellEnvFile()
+	}
+
+	cmd := &packersdk.RemoteCmd{Command: p.formatCommand("cmdCreatePrivateDir", envDir)}
+	ui.Say(fmt.Sprintf("Creating directory: %s", envDir))
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		return "", err
+	}
+	if cmd.ExitStatus() != 0 {
+		return "", fmt.Errorf("non-zero exit status while creating directory %s", envDir)
+	}
+
+	ui.Say(fmt.Sprintf("Uploading environment variables to %s", envFile))
+	if err := comm.Upload(envFile, strings.NewReader(content), nil); err != nil {
+		_ = p.removeDir(context.Background(), ui, comm, envDir)
+		return "", err
+	}
+
+	cmd = &packersdk.RemoteCmd{Command: p.formatCommand("cmdRestrictFile", envFile)}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		_ = p.removeDir(context.Background(), ui, comm, envDir)
+		return "", err
+	}
+	if cmd.ExitStatus() != 0 {
+		_ = p.removeDir(context.Background(), ui, comm, envDir)
+		return "", fmt.Errorf("non-zero exit status while restricting access to %s", envFile)
+	}
+	return envFile, nil
+}
+
+func (p *Provisioner) createShellEnvFile() string {
+	keys, envVars := p.getEnvVars()
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s='%s'\nexport %s\n", k, escapeSecret(envVars[k]), k)
+	}
+	b.WriteString("exec \"$@\"\n")
+	return b.String()
+}
+
+func (p *Provisioner) createPowerShellEnvFile() string {
+	keys, envVars := p.getEnvVars()
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "$env:%s = '%s'\r\n", k, escapePowerShellString(envVars[k]))
+	}
+	b.WriteString(strings.ReplaceAll(powerShellEnvWrapper, "\n", "\r\n"))
+	return b.String()
+}
+
+// escapePowerShellString returns a value escaped for use within a single
+// quoted PowerShell string.
+func escapePowerShellString(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEnvValues are environment variable values that need escaping in the env file.
+var testEnvValues = map[string]string{
+	"QUOTE":     `it's "quoted"`,
+	"AMPERSAND": `a&b | c`,
+	"PERCENT":   `100% %PATH%`,
+	"CARET":     `x^y ! $HOME`,
+	"NEWLINE":   "first\nsecond",
+	"EQUALS":    "a=b",
+}
+
+func TestCreateShellEnvFile(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	p := &Provisioner{}
+	p.config.EnvVars = []string{"EQUALS=a=b"}
+	p.config.Env = map[string]string{}
+	for k, v := range testEnvValues {
+		if k != "EQUALS" {
+			p.config.Env[k] = v
+		}
+	}
+
+	envFile := filepath.Join(t.TempDir(), "env.sh")
+	if err := os.WriteFile(envFile, []byte(p.createShellEnvFile()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range testEnvValues {
+		out, err := exec.Command("sh", envFile, "sh", "-c", `printf '%s' "$`+k+`"`).Output()
+		if err != nil {
+			t.Fatalf("running env file: %s", err)
+		}
+		if string(out) != want {
+			t.Errorf("%s = %q; want %q", k, out, want)
+		}
+	}
+}
+
+func TestCreatePowerShellEnvFile(t *testing.T) {
+	p := &Provisioner{}
+	p.config.Env = testEnvValues
+
+	content := p.createPowerShellEnvFile()
+	expected := []string{
+		"$env:AMPERSAND = 'a&b | c'\r\n",
+		"$env:CARET = 'x^y ! $HOME'\r\n",
+		"$env:EQUALS = 'a=b'\r\n",
+		"$env:NEWLINE = 'first\nsecond'\r\n",
+		"$env:PERCENT = '100% %PATH%'\r\n",
+		"$env:QUOTE = 'it''s \"quoted\"'\r\n",
+	}
+	if !strings.HasPrefix(content, strings.Join(expected, "")) {
+		t.Errorf("unexpected variables in env file:\n%s", content)
+	}
+	if !strings.HasSuffix(content, strings.ReplaceAll(powerShellEnvWrapper, "\n", "\r\n")) {
+		t.Error("env file does not end with the wrapper script")
+	}
+}
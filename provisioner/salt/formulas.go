@@ -0,0 +1,374 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// formula is a formula that has been fetched into the local cache.
+type formula struct {
+	FormulaConfig
+	// The local directory used as the file root for the formula
+	Dir string
+	// The commit that the ref of the formula resolved to
+	Commit string
+}
+
+// formulasLock is the content of the formulas_lock file.
+type formulasLock struct {
+	Formulas []formulaLockEntry `json:"formulas"`
+}
+
+type formulaLockEntry struct {
+	URL    string `json:"url"`
+	Ref    string `json:"ref,omitempty"`
+	Commit string `json:"commit"`
+}
+
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ----------------------------------------------------------------------------
+// Formula methods
+// ----------------------------------------------------------------------------
+func validateFormulaConfig(formulas []FormulaConfig) []error {
+	var errs []error
+
+	for i, f := range formulas {
+		if f.URL == "" {
+			errs = append(errs, fmt.Errorf("formulas %d: url must be specified", i+1))
+		}
+		if f.Subdir != "" {
+			subdir := filepath.Clean(filepath.FromSlash(f.Subdir))
+			if filepath.IsAbs(subdir) || subdir == ".." || strings.HasPrefix(subdir, ".."+string(filepath.Separator)) {
+				errs = append(errs, fmt.Errorf("formulas %d: subdir %s must be a relative path within the repository", i+1, f.Subdir))
+			}
+		}
+	}
+
+	return errs
+}
+
+// fetchFormulas fetches each formula into the Packer cache directory and
+// exports the commit that its ref resolves to, checking the commit against
+// the formulas_lock file.
+func (p *Provisioner) fetchFormulas(ctx context.Context, ui packersdk.Ui) error {
+	cacheDir, err := packersdk.CachePath("salt-formulas")
+	if err != nil {
+		return err
+	}
+
+	lock, err := readFormulasLock(p.config.FormulasLock)
+	if err != nil {
+		return err
+	}
+	locked := make(map[string]string)
+	for _, e := range lock.Formulas {
+		locked[e.URL+"@"+e.Ref] = e.Commit
+	}
+
+	p.formulas = nil
+	var entries []formulaLockEntry
+	seen := make(map[string]bool)
+	for _, f := range p.config.Formulas {
+		url := f.URL
+		if _, err := os.Stat(url); err == nil {
+			url, _ = filepath.Abs(url)
+		}
+
+		sum := sha256.Sum256([]byte(url))
+		repoDir := filepath.Join(cacheDir, "repos", hex.EncodeToString(sum[:8]))
+		commit, err := fetchFormula(ctx, repoDir, url, f.Ref)
+		if err != nil {
+			return fmt.Errorf("%s: %s", f.URL, err)
+		}
+
+		key := f.URL + "@" + f.Ref
+		if c, ok := locked[key]; ok && c != commit {
+			return fmt.Errorf("%s: %s resolves to %s but is locked to %s in %s", f.URL, formulaRef(f.Ref), commit, c, p.config.FormulasLock)
+		}
+		if !seen[key] {
+			seen[key] = true
+			entries = append(entries, formulaLockEntry{URL: f.URL, Ref: f.Ref, Commit: commit})
+		}
+
+		exportDir := filepath.Join(cacheDir, "exports", commit)
+		if err := exportFormula(ctx, repoDir, exportDir, commit); err != nil {
+			return fmt.Errorf("%s: %s", f.URL, err)
+		}
+		dir := filepath.Join(exportDir, filepath.Clean(filepath.FromSlash(f.Subdir)))
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("%s: subdir %s not found at commit %s", f.URL, f.Subdir, commit)
+		}
+
+		ui.Say(fmt.Sprintf("Formula %s (%s) resolved to commit %s", f.URL, formulaRef(f.Ref), commit))
+		p.formulas = append(p.formulas, formula{FormulaConfig: f, Dir: dir, Commit: commit})
+	}
+
+	if p.config.FormulasLock != "" {
+		return writeFormulasLock(p.config.FormulasLock, formulasLock{Formulas: entries})
+	}
+	return nil
+}
+
+// fetchFormula fetches the commit that a ref resolves to into a cached
+// repository, and returns the commit.
+func fetchFormula(ctx context.Context, repoDir string, url string, ref string) (string, error) {
+	if _, err := runGit(ctx, "", "init", "--quiet", "--bare", repoDir); err != nil {
+		return "", err
+	}
+
+	commit := ""
+	remoteRef := ref
+	if commitPattern.MatchString(ref) {
+		commit = ref
+	} else {
+		if ref == "" {
+			remoteRef = "HEAD"
+		}
+		out, err := runGit(ctx, "", "ls-remote", url, remoteRef)
+		if err != nil {
+			return "", err
+		}
+		commit, remoteRef = matchRemoteRef(out, remoteRef)
+		if commit == "" {
+			return "", fmt.Errorf("ref %s not found, commits must be specified with their full SHA", formulaRef(ref))
+		}
+	}
+
+	if _, err := runGit(ctx, repoDir, "cat-file", "-e", commit+"^{commit}"); err == nil {
+		return commit, nil
+	}
+	if _, err := runGit(ctx, repoDir, "fetch", "--quiet", "--no-tags", url, remoteRef); err != nil {
+		return "", err
+	}
+	if _, err := runGit(ctx, repoDir, "cat-file", "-e", commit+"^{commit}"); err != nil {
+		return "", fmt.Errorf("commit %s not found after fetching %s", commit, formulaRef(ref))
+	}
+	return commit, nil
+}
+
+// matchRemoteRef returns the commit and full name of the ref that best matches
+// the given name in the output of git ls-remote. Branches are preferred over
+// tags, and annotated tags are resolved to the commit they point to.
+func matchRemoteRef(lsRemote string, name string) (string, string) {
+	refs := make(map[string]string)
+	for _, line := range strings.Split(lsRemote, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+
+	candidates := []string{name}
+	if name != "HEAD" {
+		candidates = []string{"refs/heads/" + name, "refs/tags/" + name, name}
+	}
+	for _, c := range candidates {
+		if commit, ok := refs[c+"^{}"]; ok {
+			return commit, c
+		}
+		if commit, ok := refs[c]; ok {
+			return commit, c
+		}
+	}
+	return "", ""
+}
+
+// exportFormula extracts the files of a commit into exportDir, unless the
+// commit has already been exported.
+func exportFormula(ctx context.Context, repoDir string, exportDir string, commit string) error {
+	if _, err := os.Stat(exportDir); err == nil {
+		return nil
+	}
+
+	out, err := runGit(ctx, repoDir, "archive", "--format=tar", commit)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(exportDir), 0755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(exportDir), commit+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extractTar(strings.NewReader(out), tmpDir); err != nil {
+		return fmt.Errorf("error exporting commit %s: %s", commit, err)
+	}
+	if err := os.Rename(tmpDir, exportDir); err != nil {
+		// Another build may have exported the same commit
+		if _, statErr := os.Stat(exportDir); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// extractTar extracts a tar archive into dir. Symbolic links must resolve to a
+// path within dir, so that the files of a formula cannot include files from
+// elsewhere on the local system.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	var links []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return checkLinks(dir, links)
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !withinDir(dir, target) {
+			return fmt.Errorf("%s is outside of the archive", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			linkname := filepath.FromSlash(hdr.Linkname)
+			if filepath.IsAbs(linkname) || !withinDir(dir, filepath.Join(filepath.Dir(target), linkname)) {
+				return fmt.Errorf("symbolic link %s points outside of the archive", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+			links = append(links, hdr.Name)
+		}
+	}
+}
+
+// checkLinks verifies that each extracted symbolic link resolves to a path
+// within dir once any other links along the way are followed.
+func checkLinks(dir string, links []string) error {
+	if len(links) == 0 {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range links {
+		resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("symbolic link %s cannot be resolved: %s", name, err)
+		}
+		if !withinDir(root, resolved) {
+			return fmt.Errorf("symbolic link %s points outside of the archive", name)
+		}
+	}
+	return nil
+}
+
+// uploadFormulas uploads each formula to its file root on the target system.
+func (p *Provisioner) uploadFormulas(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator) error {
+	for i, f := range p.formulas {
+		ui.Say(fmt.Sprintf("Uploading formula %s at commit %s...", f.URL, f.Commit))
+		if err := p.uploadDir(ctx, ui, comm, p.remoteRootDir("formula", "base", i), f.Dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFormulasLock(file string) (formulasLock, error) {
+	var lock formulasLock
+	if file == "" {
+		return lock, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return lock, err
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return lock, fmt.Errorf("error reading %s: %s", file, err)
+	}
+	return lock, nil
+}
+
+// writeFormulasLock writes the lock file if its content has changed.
+func writeFormulasLock(file string, lock formulasLock) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if existing, err := os.ReadFile(file); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	if dir := filepath.Dir(file); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+func formulaRef(ref string) string {
+	if ref == "" {
+		return "default branch"
+	}
+	return ref
+}
@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is a file, directory or symbolic link written by testTar.
+type tarEntry struct {
+	name string
+	body string
+	link string
+	dir  bool
+}
+
+func testTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.dir:
+			hdr = &tar.Header{Name: e.name, Mode: 0755, Typeflag: tar.TypeDir}
+		case e.link != "":
+			hdr = &tar.Header{Name: e.name, Linkname: e.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTar(t *testing.T) {
+	dir := t.TempDir()
+	entries := []tarEntry{
+		{name: "nginx/", dir: true},
+		{name: "nginx/init.sls", body: "nginx: pkg.installed"},
+		{name: "nginx/files/", dir: true},
+		{name: "nginx/files/default.conf", body: "server {}"},
+		{name: "nginx/default.conf", link: "files/default.conf"},
+		{name: "files", link: "nginx/files"},
+	}
+	if err := extractTar(testTar(t, entries), dir); err != nil {
+		t.Fatalf("extractTar returned error: %s", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "nginx", "default.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "server {}" {
+		t.Errorf("nginx/default.conf = %q; want %q", data, "server {}")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "files", "default.conf")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExtractTarRejectsLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		want    string
+	}{
+		{
+			name:    "absolute link",
+			entries: []tarEntry{{name: "creds", link: "/home/builder/.aws"}},
+			want:    "points outside of the archive",
+		},
+		{
+			name:    "parent directory link",
+			entries: []tarEntry{{name: "nginx/creds", link: "../../.aws"}},
+			want:    "points outside of the archive",
+		},
+		{
+			name: "link through another link",
+			entries: []tarEntry{
+				{name: "a/", dir: true},
+				{name: "a/up", link: ".."},
+				{name: "parent", link: "a/up/.."},
+			},
+			want: "points outside of the archive",
+		},
+		{
+			name:    "dangling link",
+			entries: []tarEntry{{name: "missing", link: "nginx/missing.sls"}},
+			want:    "cannot be resolved",
+		},
+		{
+			name:    "path outside of the archive",
+			entries: []tarEntry{{name: "../escape.sls", body: "x"}},
+			want:    "is outside of the archive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := extractTar(testTar(t, tt.entries), t.TempDir())
+			if err == nil {
+				t.Fatal("extractTar succeeded; want an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// saltIgnoreFile is the name of the file at the root of an uploaded directory
+// that lists the files to exclude, using gitignore syntax.
+const saltIgnoreFile = ".saltignore"
+
+// ignoreRule is a single gitignore style pattern.
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreRules are applied in order, so the last rule that matches a path
+// determines whether it is excluded.
+type ignoreRules []ignoreRule
+
+// ----------------------------------------------------------------------------
+// Upload exclusion methods
+// ----------------------------------------------------------------------------
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var r ignoreRule
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	// A pattern containing a slash, other than a trailing one, is relative to
+	// the root of the directory
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	r.pattern = line
+	return r, true
+}
+
+func validateIgnorePatterns(patterns []string, cfg string) []error {
+	var errs []error
+	for _, p := range patterns {
+		r, ok := parseIgnoreRule(p)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %q is not a valid pattern", cfg, p))
+			continue
+		}
+		for _, part := range strings.Split(r.pattern, "/") {
+			if _, err := path.Match(part, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a valid pattern: %s", cfg, p, err))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// readUploadRules returns the rules that exclude files from an uploaded
+// directory: the rules of any .saltignore file in the directory, followed by
+// the exclude patterns, followed by the include patterns, which re-include
+// files that would otherwise be excluded.
+func readUploadRules(dir string, exclude []string, include []string) (ignoreRules, error) {
+	var rules ignoreRules
+
+	f, err := os.Open(filepath.Join(dir, saltIgnoreFile))
+	if err == nil {
+		defer f.Close()
+		rules = append(rules, ignoreRule{pattern: saltIgnoreFile, anchored: true})
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if r, ok := parseIgnoreRule(scanner.Text()); ok {
+				rules = append(rules, r)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", f.Name(), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading %s: %s", filepath.Join(dir, saltIgnoreFile), err)
+	}
+
+	for _, p := range exclude {
+		if r, ok := parseIgnoreRule(p); ok {
+			rules = append(rules, r)
+		}
+	}
+	for _, p := range include {
+		if r, ok := parseIgnoreRule(strings.TrimPrefix(p, "!")); ok {
+			r.negate = true
+			rules = append(rules, r)
+		}
+	}
+
+	return rules, nil
+}
+
+// excluded reports whether the slash separated path, relative to the root of
+// the uploaded directory, is excluded.
+func (rules ignoreRules) excluded(rel string, isDir bool) bool {
+	excluded := false
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.matches(rel) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+func (r ignoreRule) matches(rel string) bool {
+	if r.anchored {
+		return matchGlob(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+	}
+	ok, _ := path.Match(r.pattern, path.Base(rel))
+	return ok
+}
+
+// matchGlob matches path segments against pattern segments, where a ** segment
+// matches any number of path segments. A trailing ** only matches the contents
+// of a directory, not the directory itself.
+func matchGlob(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return len(segments) > 0
+		}
+		for i := 0; i <= len(segments); i++ {
+			if matchGlob(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchGlob(pattern[1:], segments[1:])
+}
+
+// stageFiles copies the files into a new temporary directory at their relative
+// paths, and returns the directory. The caller must remove the directory.
+func stageFiles(files []slsFile) (string, error) {
+	dir, err := os.MkdirTemp("", "packer-salt-")
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if err := copyFile(f.Source, filepath.Join(dir, filepath.FromSlash(f.Path))); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
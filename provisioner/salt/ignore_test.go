@@ -0,0 +1,249 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestParseIgnoreRule(t *testing.T) {
+	tests := []struct {
+		line string
+		want ignoreRule
+		ok   bool
+	}{
+		{"", ignoreRule{}, false},
+		{"   ", ignoreRule{}, false},
+		{"# comment", ignoreRule{}, false},
+		{"/", ignoreRule{}, false},
+		{"*.pyc", ignoreRule{pattern: "*.pyc"}, true},
+		{"*.pyc  ", ignoreRule{pattern: "*.pyc"}, true},
+		{"!keep.sls", ignoreRule{pattern: "keep.sls", negate: true}, true},
+		{`\#literal`, ignoreRule{pattern: "#literal"}, true},
+		{`\!literal`, ignoreRule{pattern: "!literal"}, true},
+		{"build/", ignoreRule{pattern: "build", dirOnly: true}, true},
+		{"/top.sls", ignoreRule{pattern: "top.sls", anchored: true}, true},
+		{"docs/*.md", ignoreRule{pattern: "docs/*.md", anchored: true}, true},
+		{"**/tests/", ignoreRule{pattern: "**/tests", dirOnly: true, anchored: true}, true},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseIgnoreRule(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseIgnoreRule(%q) = %+v, %t; want %+v, %t", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIgnoreRulesExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{"no rules", nil, "web/init.sls", false, false},
+
+		// Patterns without a slash match the name at any depth
+		{"name at root", []string{"*.pyc"}, "mod.pyc", false, true},
+		{"name in subdirectory", []string{"*.pyc"}, "_modules/mod.pyc", false, true},
+		{"name does not match", []string{"*.pyc"}, "_modules/mod.py", false, false},
+		{"directory name", []string{".git"}, "formula/.git", true, true},
+
+		// Patterns with a slash are anchored to the root
+		{"leading slash at root", []string{"/top.sls"}, "top.sls", false, true},
+		{"leading slash in subdirectory", []string{"/top.sls"}, "web/top.sls", false, false},
+		{"middle slash", []string{"docs/*.md"}, "docs/readme.md", false, true},
+		{"middle slash in subdirectory", []string{"docs/*.md"}, "web/docs/readme.md", false, false},
+		{"middle slash wildcard is one level", []string{"docs/*.md"}, "docs/api/readme.md", false, false},
+
+		// ** matches any number of directories
+		{"leading ** at root", []string{"**/tests"}, "tests", true, true},
+		{"leading ** in subdirectory", []string{"**/tests"}, "web/nginx/tests", true, true},
+		{"middle ** with no directories", []string{"web/**/test.sls"}, "web/test.sls", false, true},
+		{"middle ** with directories", []string{"web/**/test.sls"}, "web/a/b/test.sls", false, true},
+		{"middle ** other root", []string{"web/**/test.sls"}, "db/a/test.sls", false, false},
+		{"trailing ** contents", []string{"build/**"}, "build/out/file", false, true},
+		{"trailing ** not the directory", []string{"build/**"}, "build", true, false},
+
+		// Patterns with a trailing slash only match directories
+		{"directory only matches directory", []string{"cache/"}, "web/cache", true, true},
+		{"directory only skips file", []string{"cache/"}, "web/cache", false, false},
+
+		// The last matching rule wins
+		{"negation after exclusion", []string{"*.sls", "!keep.sls"}, "keep.sls", false, false},
+		{"negation keeps others excluded", []string{"*.sls", "!keep.sls"}, "drop.sls", false, true},
+		{"exclusion after negation", []string{"!keep.sls", "*.sls"}, "keep.sls", false, true},
+		{"negation without exclusion", []string{"!keep.sls"}, "keep.sls", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules ignoreRules
+			for _, p := range tt.patterns {
+				if r, ok := parseIgnoreRule(p); ok {
+					rules = append(rules, r)
+				}
+			}
+			if got := rules.excluded(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("excluded(%q, %t) with %q = %t; want %t", tt.path, tt.isDir, tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadUploadRules(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		saltIgnoreFile:        "# local files\n*.bak\nsecrets/\n!important.bak\n",
+		"top.sls":             "",
+		"web/init.sls":        "",
+		"web/init.sls.bak":    "",
+		"web/important.bak":   "",
+		"secrets/key.sls":     "",
+		"tests/test_web.py":   "",
+		"tests/fixtures.yaml": "",
+		"docs/readme.md":      "",
+	})
+
+	tests := []struct {
+		name    string
+		exclude []string
+		include []string
+		want    []string
+	}{
+		{
+			name: "saltignore only",
+			want: []string{"docs/readme.md", "tests/fixtures.yaml", "tests/test_web.py", "top.sls", "web/important.bak", "web/init.sls"},
+		},
+		{
+			name:    "exclude after saltignore",
+			exclude: []string{"tests/", "*.md"},
+			want:    []string{"top.sls", "web/important.bak", "web/init.sls"},
+		},
+		{
+			name:    "include overrides exclude",
+			exclude: []string{"tests/*"},
+			include: []string{"tests/fixtures.yaml"},
+			want:    []string{"docs/readme.md", "tests/fixtures.yaml", "top.sls", "web/important.bak", "web/init.sls"},
+		},
+		{
+			name:    "include overrides saltignore",
+			include: []string{"!web/init.sls.bak"},
+			want:    []string{"docs/readme.md", "tests/fixtures.yaml", "tests/test_web.py", "top.sls", "web/important.bak", "web/init.sls", "web/init.sls.bak"},
+		},
+		{
+			name:    "include cannot reach into an excluded directory",
+			include: []string{"secrets/key.sls"},
+			want:    []string{"docs/readme.md", "tests/fixtures.yaml", "tests/test_web.py", "top.sls", "web/important.bak", "web/init.sls"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := readUploadRules(dir, tt.exclude, tt.include)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, err := dirFiles(dir, rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range files {
+				got = append(got, f.Path)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStageFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"top.sls":      "base:\n  '*':\n    - web\n",
+		"web/init.sls": "nginx:\n  pkg.installed\n",
+		"web/skip.sls": "",
+	})
+
+	stageDir, err := stageFiles([]slsFile{
+		{Source: filepath.Join(dir, "top.sls"), Path: "top.sls"},
+		{Source: filepath.Join(dir, "web", "init.sls"), Path: "web/init.sls"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stageDir)
+
+	files, err := dirFiles(stageDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d staged files; want 2", len(files))
+	}
+	data, err := os.ReadFile(filepath.Join(stageDir, "web", "init.sls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "nginx:\n  pkg.installed\n" {
+		t.Errorf("staged content = %q", data)
+	}
+}
+
+func TestUploadDirWithExclusions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		saltIgnoreFile:    "tests/\n",
+		"top.sls":         "",
+		"web/init.sls":    "",
+		"tests/test.yaml": "",
+	})
+
+	p := &Provisioner{}
+	p.config.TargetOS = "linux"
+	comm := new(packersdk.MockCommunicator)
+	if err := p.uploadDir(context.Background(), packersdk.TestUi(t), comm, "/tmp/states", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The remaining files are uploaded as a single directory, not file by file
+	if comm.UploadCalled {
+		t.Errorf("files were uploaded separately")
+	}
+	if comm.UploadDirDst != "/tmp/states" {
+		t.Errorf("UploadDir destination = %q; want /tmp/states", comm.UploadDirDst)
+	}
+	if strings.HasPrefix(comm.UploadDirSrc, dir) {
+		t.Errorf("UploadDir source = %q; want a staging directory", comm.UploadDirSrc)
+	}
+	if _, err := os.Stat(comm.UploadDirSrc); !os.IsNotExist(err) {
+		t.Errorf("staging directory %s was not removed", comm.UploadDirSrc)
+	}
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
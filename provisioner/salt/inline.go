@@ -0,0 +1,112 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// ----------------------------------------------------------------------------
+// Inline state methods
+// ----------------------------------------------------------------------------
+
+// resolveInlineStates validates the names of inline states and returns the
+// files they are written to, in lexical order of their names.
+func (p *Provisioner) resolveInlineStates() ([]slsFile, []error) {
+	var errs []error
+
+	var names []string
+	for name := range p.config.InlineStates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stateNames := make(map[string]string)
+	for _, f := range p.stateFiles {
+		stateNames[f.Name] = f.Source
+	}
+
+	var resolved []slsFile
+	for _, name := range names {
+		parts := strings.Split(name, ".")
+		valid := true
+		for _, part := range parts {
+			if part == "" || strings.ContainsAny(part, `/\`) {
+				valid = false
+			}
+		}
+		if !valid {
+			errs = append(errs, fmt.Errorf("inline_states: %q is not a valid SLS name", name))
+			continue
+		}
+		if strings.TrimSpace(p.config.InlineStates[name]) == "" {
+			errs = append(errs, fmt.Errorf("inline_states: %s must not be empty", name))
+			continue
+		}
+
+		rel := path.Join(parts...) + ".sls"
+		if source, ok := stateNames[name]; ok {
+			errs = append(errs, fmt.Errorf("inline_states: %s is already defined by %s", name, source))
+			continue
+		}
+		if p.config.StateTree != "" {
+			if _, err := os.Stat(filepath.Join(p.config.StateTree, filepath.FromSlash(rel))); err == nil {
+				errs = append(errs, fmt.Errorf("inline_states: %s would overwrite %s in state_tree", name, rel))
+				continue
+			}
+		}
+
+		resolved = append(resolved, slsFile{Source: fmt.Sprintf("inline_states[%q]", name), Path: rel, Name: name})
+	}
+
+	return resolved, errs
+}
+
+// uploadInlineStates writes each inline state to the state directory.
+func (p *Provisioner) uploadInlineStates(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator) error {
+	for _, f := range p.inlineStates {
+		remoteFile := path.Join(filepath.ToSlash(p.config.StateDir), f.Path)
+		if err := p.createDir(ctx, ui, comm, path.Dir(remoteFile)); err != nil {
+			return err
+		}
+
+		ui.Say(fmt.Sprintf("Uploading inline state %s to %s", f.Name, remoteFile))
+		content := renderInlineState(p.config.InlineStates[f.Name])
+		if err := comm.Upload(remoteFile, strings.NewReader(content), nil); err != nil {
+			return fmt.Errorf("error uploading inline state %s: %s", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// renderInlineState returns the SLS content for an inline state. A JSON object,
+// such as one produced by jsonencode, is rendered as YAML using only the yaml
+// renderer. Any other value is used as is, so it is rendered by the default
+// Jinja and YAML renderers.
+func renderInlineState(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if strings.HasPrefix(trimmed, "{") {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(trimmed), &data); err == nil {
+			var buf bytes.Buffer
+			_ = json.Indent(&buf, []byte(trimmed), "", "  ")
+			return "#!yaml\n" + buf.String() + "\n"
+		}
+	}
+
+	if !strings.HasSuffix(value, "\n") {
+		value += "\n"
+	}
+	return value
+}
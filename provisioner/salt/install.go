@@ -0,0 +1,213 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+var installPackageCommandMap = map[string]string{
+	".rpm": "cmdInstallRpm",
+	".deb": "cmdInstallDeb",
+	".msi": "cmdInstallMsi",
+	".exe": "cmdInstallExe",
+}
+
+// ----------------------------------------------------------------------------
+// Salt installation methods
+// ----------------------------------------------------------------------------
+func (p *Provisioner) validateInstallConfig() []error {
+	var errs []error
+
+	switch p.config.InstallMethod {
+	case "onedir", "bootstrap":
+		if len(p.config.InstallPackages) != 0 {
+			errs = append(errs, fmt.Errorf("install_packages can only be used when install_method is 'package'"))
+		}
+	case "package":
+		if len(p.config.InstallPackages) == 0 {
+			errs = append(errs, fmt.Errorf("install_packages must be specified when install_method is 'package'"))
+		}
+		if p.config.BootstrapScript != "" {
+			errs = append(errs, fmt.Errorf("bootstrap_script cannot be used when install_method is 'package'"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("permitted value for install_method is one of: onedir, bootstrap, package"))
+	}
+
+	if p.config.BootstrapScript != "" {
+		if err := validateFileConfig(p.config.BootstrapScript, "bootstrap_script"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, f := range p.config.InstallPackages {
+		if err := validateFileConfig(f, "install_packages"); err != nil {
+			errs = append(errs, err)
+		}
+		if _, ok := installPackageCommandMap[strings.ToLower(filepath.Ext(f))]; !ok {
+			errs = append(errs, fmt.Errorf("install_packages: %s is not a supported package type (.rpm, .deb, .msi, .exe)", f))
+		}
+	}
+
+	return errs
+}
+
+func (p *Provisioner) installSalt(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator) error {
+	ui.Say("Checking for an existing Salt installation...")
+	if installed := p.getSaltVersion(ctx, comm); installed != "" {
+		if p.config.SaltVersion == "" || saltVersionMatches(installed, p.config.SaltVersion) {
+			ui.Say(fmt.Sprintf("Salt %s is already installed, skipping installation", installed))
+			return nil
+		}
+		ui.Say(fmt.Sprintf("Salt %s is installed but version %s was requested", installed, p.config.SaltVersion))
+	}
+
+	installDir := p.getConfig("configInstallDir")
+	if err := p.createDir(ctx, ui, comm, installDir); err != nil {
+		return fmt.Errorf("error creating installation directory: %s", err)
+	}
+	defer func() {
+		_ = p.removeDir(context.Background(), ui, comm, installDir)
+	}()
+
+	var err error
+	if p.config.InstallMethod == "package" {
+		err = p.installSaltPackages(ctx, ui, comm, installDir)
+	} else {
+		err = p.installSaltBootstrap(ctx, ui, comm, installDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	installed := p.getSaltVersion(ctx, comm)
+	if installed == "" {
+		return fmt.Errorf("salt-call could not be found after installation")
+	}
+	if p.config.SaltVersion != "" && !saltVersionMatches(installed, p.config.SaltVersion) {
+		return fmt.Errorf("installed Salt version %s does not match requested version %s", installed, p.config.SaltVersion)
+	}
+	ui.Say(fmt.Sprintf("Salt %s installed", installed))
+
+	return nil
+}
+
+func (p *Provisioner) installSaltBootstrap(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, installDir string) error {
+	remoteScript := filepath.ToSlash(filepath.Join(installDir, p.getConfig("configBootstrapFile")))
+
+	if p.config.BootstrapScript != "" {
+		if err := p.uploadFile(ui, comm, remoteScript, p.config.BootstrapScript); err != nil {
+			return fmt.Errorf("error uploading bootstrap_script: %s", err)
+		}
+	} else {
+		ui.Say("Downloading Salt bootstrap script...")
+		command := p.formatCommand("cmdDownload", remoteScript, p.getConfig("configBootstrapURL"))
+		if err := p.runInstallCommand(ctx, ui, comm, command); err != nil {
+			return fmt.Errorf("error downloading bootstrap script: %s", err)
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Installing Salt using the %s method...", p.config.InstallMethod))
+	command := p.formatCommand("cmdBootstrap", remoteScript, p.getBootstrapArgs())
+	return p.runInstallCommand(ctx, ui, comm, command)
+}
+
+func (p *Provisioner) installSaltPackages(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, installDir string) error {
+	var remotePackages []string
+	var rawCommand string
+
+	for _, f := range p.config.InstallPackages {
+		cmdName := installPackageCommandMap[strings.ToLower(filepath.Ext(f))]
+		if p.getCommand(cmdName) == "" {
+			return fmt.Errorf("package %s cannot be installed on a %s target", f, p.config.TargetOS)
+		}
+		if rawCommand != "" && rawCommand != p.getCommand(cmdName) {
+			return fmt.Errorf("install_packages must all be of the same package type")
+		}
+		rawCommand = p.getCommand(cmdName)
+
+		remotePackage := filepath.ToSlash(filepath.Join(installDir, filepath.Base(f)))
+		if err := p.uploadFile(ui, comm, remotePackage, f); err != nil {
+			return fmt.Errorf("error uploading install_packages: %s", err)
+		}
+		remotePackages = append(remotePackages, remotePackage)
+	}
+
+	ui.Say("Installing Salt from local packages...")
+	if p.config.TargetOS == "windows" {
+		for _, remotePackage := range remotePackages {
+			if err := p.runInstallCommand(ctx, ui, comm, p.withSudo(fmt.Sprintf(rawCommand, remotePackage))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	quoted := make([]string, len(remotePackages))
+	for i, remotePackage := range remotePackages {
+		quoted[i] = fmt.Sprintf("'%s'", remotePackage)
+	}
+	return p.runInstallCommand(ctx, ui, comm, p.withSudo(fmt.Sprintf(rawCommand, strings.Join(quoted, " "))))
+}
+
+func (p *Provisioner) runInstallCommand(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, command string) error {
+	ui.Say(fmt.Sprintf("Executing: %s", command))
+	cmd := &packersdk.RemoteCmd{Command: command}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		return err
+	}
+	if cmd.ExitStatus() != 0 {
+		return fmt.Errorf("non-zero exit status: %d", cmd.ExitStatus())
+	}
+	return nil
+}
+
+func (p *Provisioner) getBootstrapArgs() string {
+	var args []string
+
+	if p.config.TargetOS == "windows" {
+		if p.config.SaltVersion != "" {
+			args = append(args, "-Version", p.config.SaltVersion)
+		}
+	} else {
+		installType := "onedir"
+		if p.config.InstallMethod == "bootstrap" {
+			installType = "stable"
+		}
+		args = append(args, "-P", installType)
+		if p.config.SaltVersion != "" {
+			args = append(args, p.config.SaltVersion)
+		}
+	}
+	if p.config.BootstrapArgs != "" {
+		args = append(args, p.config.BootstrapArgs)
+	}
+
+	return strings.Join(args, " ")
+}
+
+// getSaltVersion returns the version reported by salt-call on the target system,
+// or an empty string if salt-call could not be run.
+func (p *Provisioner) getSaltVersion(ctx context.Context, comm packersdk.Communicator) string {
+	out, exitStatus, err := p.runCommandWithOutput(ctx, comm, p.formatCommand("cmdSaltVersion", p.config.SaltCallPath))
+	if err != nil || exitStatus != 0 {
+		return ""
+	}
+
+	// Output is in the form "salt-call 3007.1 (Chlorine)"
+	fields := strings.Fields(out)
+	if len(fields) < 2 || fields[0] != "salt-call" {
+		return ""
+	}
+	return fields[1]
+}
+
+func saltVersionMatches(installed string, requested string) bool {
+	return installed == requested || strings.HasPrefix(installed, requested+".")
+}
@@ -0,0 +1,150 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// syncTypes are the module types that have a saltutil.sync_* function.
+var syncTypes = map[string]bool{
+	"beacons":      true,
+	"clouds":       true,
+	"engines":      true,
+	"executors":    true,
+	"grains":       true,
+	"log_handlers": true,
+	"matchers":     true,
+	"modules":      true,
+	"output":       true,
+	"pillar":       true,
+	"proxymodules": true,
+	"renderers":    true,
+	"returners":    true,
+	"sdb":          true,
+	"serializers":  true,
+	"states":       true,
+	"thorium":      true,
+	"utils":        true,
+}
+
+// ----------------------------------------------------------------------------
+// Custom module methods
+// ----------------------------------------------------------------------------
+func validateExtensionModules(dir string) error {
+	if err := validateDirConfig(dir, "extension_modules"); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("extension_modules: %s invalid: %s", dir, err)
+	}
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), "_") {
+			return nil
+		}
+	}
+	return fmt.Errorf("extension_modules: %s must contain at least one module directory, such as _modules or _states", dir)
+}
+
+// syncModules syncs custom modules from the file roots to the minion, and
+// reports the modules that were synced.
+func (p *Provisioner) syncModules(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, envVars string) error {
+	functions := []string{"saltutil.sync_all"}
+	if len(p.config.SyncTypes) > 0 {
+		functions = nil
+		for _, t := range p.config.SyncTypes {
+			functions = append(functions, "saltutil.sync_"+t)
+		}
+	}
+
+	for _, function := range functions {
+		args := function
+		if p.config.Saltenv != "" {
+			args += " saltenv=" + p.config.Saltenv
+		}
+		logFile := p.saltLogFile()
+		command, err := p.saltCallCommand(envVars, logFile, args)
+		if err != nil {
+			return err
+		}
+
+		ui.Say(fmt.Sprintf("Syncing modules: %s", command))
+		out, exitStatus, err := p.runSaltCall(ctx, ui, comm, command, logFile, p.config.StateTimeout)
+		if err != nil {
+			return err
+		}
+
+		synced, err := parseSyncResult(function, out)
+		if err != nil {
+			if exitStatus != 0 {
+				return fmt.Errorf("non-zero exit status: %d: %s", exitStatus, err)
+			}
+			return err
+		}
+		if exitStatus != 0 {
+			return fmt.Errorf("non-zero exit status: %d", exitStatus)
+		}
+		printSyncResult(ui, synced)
+	}
+
+	return nil
+}
+
+// parseSyncResult returns the names of the modules that were synced, by module
+// type, from the JSON output of a saltutil.sync_* function.
+func parseSyncResult(function string, output []byte) (map[string][]string, error) {
+	var result struct {
+		Local json.RawMessage `json:"local"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(output), &result); err != nil || result.Local == nil {
+		return nil, fmt.Errorf("unable to parse output of %s", function)
+	}
+
+	synced := make(map[string][]string)
+	if function == "saltutil.sync_all" {
+		if err := json.Unmarshal(result.Local, &synced); err == nil {
+			return synced, nil
+		}
+	} else {
+		var names []string
+		if err := json.Unmarshal(result.Local, &names); err == nil {
+			synced[strings.TrimPrefix(function, "saltutil.sync_")] = names
+			return synced, nil
+		}
+	}
+
+	// Salt reports an error as a string
+	var message string
+	if err := json.Unmarshal(result.Local, &message); err == nil {
+		return nil, fmt.Errorf("%s failed: %s", function, message)
+	}
+	return nil, fmt.Errorf("unable to parse output of %s", function)
+}
+
+func printSyncResult(ui packersdk.Ui, synced map[string][]string) {
+	var types []string
+	for t, names := range synced {
+		if len(names) > 0 {
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		ui.Say("No modules were synced")
+		return
+	}
+
+	sort.Strings(types)
+	for _, t := range types {
+		ui.Say(fmt.Sprintf("Synced %s: %s", t, strings.Join(synced[t], ", ")))
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// pillarTreeTopFile is the name that the top file of the pillar tree is
+// uploaded as when it is merged with a generated top file.
+const pillarTreeTopFile = "packer-pillar-tree-top.sls"
+
+// ----------------------------------------------------------------------------
+// Pillar methods
+// ----------------------------------------------------------------------------
+
+// usesPillarDir reports whether pillar data is uploaded to the pillar directory.
+func (p *Provisioner) usesPillarDir() bool {
+	return p.config.PillarTree != "" || len(p.pillarFiles) > 0
+}
+
+// pillarTopNames returns the SLS names of the pillar files to be assigned to the
+// minion by a generated top file, or nil if no top file is to be generated
+// because pillar_files includes its own top.sls.
+func (p *Provisioner) pillarTopNames() []string {
+	var names []string
+	for _, f := range p.pillarFiles {
+		if f.Path == "top.sls" {
+			return nil
+		}
+		if f.Name != "" {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// pillarTreeHasTop reports whether the pillar tree includes a top file.
+func (p *Provisioner) pillarTreeHasTop() bool {
+	if p.config.PillarTree == "" {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(p.config.PillarTree, "top.sls"))
+	return err == nil && !info.IsDir()
+}
+
+// createPillarTop returns a top file that assigns the pillar files to all
+// minions in the order in which they are listed, so that later files override
+// earlier ones. When the pillar tree has its own top file, the generated top
+// file renders the tree's top file and assigns the pillar files after all of
+// the pillar it assigns.
+func (p *Provisioner) createPillarTop(names []string) string {
+	var b strings.Builder
+	if !p.pillarTreeHasTop() {
+		b.WriteString("base:\n  '*':\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "    - %s\n", name)
+		}
+		return b.String()
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("'%s'", name)
+	}
+	fmt.Fprintf(&b, "{%%- import_yaml '%s' as tree_top with context %%}\n", pillarTreeTopFile)
+	b.WriteString("{%- set top = tree_top if tree_top else {} %}\n")
+	b.WriteString("{%- set base = top.setdefault('base', {}) %}\n")
+	b.WriteString("{%- set all = base.pop('*', []) %}\n")
+	fmt.Fprintf(&b, "{%%- do base.update({'*': all + [%s]}) %%}\n", strings.Join(quoted, ", "))
+	b.WriteString("{{ top | yaml(False) }}\n")
+	return b.String()
+}
+
+// uploadPillarTop generates and uploads a top file for the pillar files. Any
+// top file from the pillar tree is first moved aside so that it can be merged.
+func (p *Provisioner) uploadPillarTop(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator) error {
+	names := p.pillarTopNames()
+	if len(names) == 0 {
+		return nil
+	}
+
+	pillarDir := filepath.ToSlash(p.config.PillarDir)
+	if p.pillarTreeHasTop() {
+		treeTop := path.Join(pillarDir, pillarTreeTopFile)
+		if err := p.uploadFile(ui, comm, treeTop, filepath.Join(p.config.PillarTree, "top.sls")); err != nil {
+			return err
+		}
+	}
+
+	topFile := path.Join(pillarDir, "top.sls")
+	ui.Say(fmt.Sprintf("Uploading generated pillar top file to %s", topFile))
+	return comm.Upload(topFile, strings.NewReader(p.createPillarTop(names)), nil)
+}
@@ -1,7 +1,7 @@
 // Copyright (c) HashiCorp, Inc.
 // SPDX-License-Identifier: MPL-2.0
 
-//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,ReportConfig,StateConfig,RootsConfig,FormulaConfig
 //go:generate packer-sdc struct-markdown
 
 package salt
@@ -9,9 +9,12 @@ package salt
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -26,25 +29,70 @@ import (
 )
 
 var saltConfigMap = map[string]string{
-	"configStateDir_linux":    "/tmp/packer-provisioner-salt",
-	"configStateDir_windows":  "C:/Windows/Temp/packer-provisioner-salt",
-	"configPillarDir_linux":   "/tmp/packer-provisioner-salt-pillar",
-	"configPillarDir_windows": "C:/Windows/Temp/packer-provisioner-salt-pillar",
-	"configEnvFormat_linux":   "%s='%s' ",
-	"configEnvFormat_windows": "set \"%s=%s\" & ",
+	"configStateDir_linux":        "/tmp/packer-provisioner-salt",
+	"configStateDir_windows":      "C:/Windows/Temp/packer-provisioner-salt",
+	"configPillarDir_linux":       "/tmp/packer-provisioner-salt-pillar",
+	"configPillarDir_windows":     "C:/Windows/Temp/packer-provisioner-salt-pillar",
+	"configEnvFormat_linux":       "%s='%s' ",
+	"configEnvFormat_windows":     "set \"%s=%s\" & ",
+	"configInstallDir_linux":      "/tmp/packer-provisioner-salt-install",
+	"configInstallDir_windows":    "C:/Windows/Temp/packer-provisioner-salt-install",
+	"configBootstrapURL_linux":    "https://github.com/saltstack/salt-bootstrap/releases/latest/download/bootstrap-salt.sh",
+	"configBootstrapURL_windows":  "https://github.com/saltstack/salt-bootstrap/releases/latest/download/bootstrap-salt.ps1",
+	"configBootstrapFile_linux":   "bootstrap-salt.sh",
+	"configBootstrapFile_windows": "bootstrap-salt.ps1",
+	"configConfigDir_linux":       "/tmp/packer-provisioner-salt-config",
+	"configConfigDir_windows":     "C:/Windows/Temp/packer-provisioner-salt-config",
+	"configRootsDir_linux":        "/tmp/packer-provisioner-salt-roots",
+	"configRootsDir_windows":      "C:/Windows/Temp/packer-provisioner-salt-roots",
+	"configEnvDir_linux":          "/tmp/packer-provisioner-salt-env",
+	"configEnvDir_windows":        "C:/Windows/Temp/packer-provisioner-salt-env",
+	"configEnvFile_linux":         "packer-salt-env.sh",
+	"configEnvFile_windows":       "packer-salt-env.ps1",
+	"configLogFile_linux":         "packer-salt-call.log",
+	"configLogFile_windows":       "packer-salt-call.log",
+	"configPillarArg_linux":       "pillar='%s'",
+	"configPillarArg_windows":     "pillar=\"%s\"",
 }
 
 var saltCommandMap = map[string]string{
-	"cmdCreateDir_linux":        "mkdir -p '%s'",
-	"cmdCreateDir_windows":      "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command {New-Item -ItemType Directory -Path %s -Force}",
-	"cmdDeleteDir_linux":        "rm -rf '%s'",
-	"cmdDeleteDir_windows":      "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command {Remove-Item -Recurse -Force %s}",
-	"cmdSaltCall_linux":         "sudo %ssalt-call --local --log-level=%s --file-root=%s state.apply %s",
-	"cmdSaltCall_windows":       "%ssalt-call --local --log-level=%s --file-root=%s state.apply %s",
-	"cmdSaltCallPillar_linux":   "sudo %ssalt-call --local --log-level=%s --file-root=%s --pillar-root=%s state.apply %s",
-	"cmdSaltCallPillar_windows": "%ssalt-call --local --log-level=%s --file-root=%s --pillar-root=%s state.apply %s",
+	"cmdCreateDir_linux":          "mkdir -p '%s'",
+	"cmdCreateDir_windows":        "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command {New-Item -ItemType Directory -Path %s -Force}",
+	"cmdDeleteDir_linux":          "rm -rf '%s'",
+	"cmdDeleteDir_windows":        "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command {Remove-Item -Recurse -Force %s}",
+	"cmdSaltCall_linux":           "{{.Sudo}}{{.Vars}}'{{.SaltCall}}' --local --retcode-passthrough --out=json --log-level={{.LogLevel}} --log-file={{.LogFile}} --log-file-level=info {{.ExtraArgs}} {{.Target}}",
+	"cmdSaltCall_windows":         "{{.Vars}}\"{{.SaltCall}}\" --local --retcode-passthrough --out=json --log-level={{.LogLevel}} --log-file={{.LogFile}} --log-file-level=info {{.ExtraArgs}} {{.Target}}",
+	"cmdSaltVersion_linux":        "'%s' --version",
+	"cmdSaltVersion_windows":      "\"%s\" --version",
+	"cmdDownload_linux":           "curl -fsSL -o '%s' '%s'",
+	"cmdDownload_windows":         "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command {[Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12; Invoke-WebRequest -UseBasicParsing -OutFile %s -Uri %s}",
+	"cmdBootstrap_linux":          "{{.Sudo}}sh '%s' %s",
+	"cmdBootstrap_windows":        "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -File %s %s",
+	"cmdInstallRpm_linux":         "{{.Sudo}}yum install -y %s",
+	"cmdInstallDeb_linux":         "{{.Sudo}}apt-get install -y %s",
+	"cmdInstallMsi_windows":       "msiexec.exe /i %s /qn /norestart",
+	"cmdInstallExe_windows":       "%s /S",
+	"cmdEnvWrapper_linux":         "sh '%s' ",
+	"cmdEnvWrapper_windows":       "powershell.exe -NoProfile -ExecutionPolicy Bypass -File %s ",
+	"cmdCreatePrivateDir_linux":   "{{.Sudo}}rm -rf '%[1]s' && umask 077 && mkdir '%[1]s'",
+	"cmdCreatePrivateDir_windows": "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command \"$ErrorActionPreference = 'Stop'; if (Test-Path '%[1]s') { Remove-Item -Recurse -Force '%[1]s' }; New-Item -ItemType Directory -Path '%[1]s' | Out-Null; icacls.exe '%[1]s' /inheritance:r /grant:r '*S-1-5-18:(OI)(CI)F' '*S-1-5-32-544:(OI)(CI)F' | Out-Null; exit $LASTEXITCODE\"",
+	"cmdRestrictFile_linux":       "{{.Sudo}}chown root:root '%[1]s' && {{.Sudo}}chmod 0600 '%[1]s'",
+	"cmdRestrictFile_windows":     "icacls.exe %s /inheritance:r /grant:r *S-1-5-18:F *S-1-5-32-544:F",
+	"cmdDeleteFile_linux":         "{{.Sudo}}rm -f '%s'",
+	"cmdDeleteFile_windows":       "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command {Remove-Item -Force %s}",
+	"cmdKillSalt_linux":           "{{.Sudo}}pkill -TERM -f '[s]alt-call --local'",
+	"cmdKillSalt_windows":         "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command \"Get-CimInstance Win32_Process | Where-Object { $_.CommandLine -match '[s]alt-call.*--local' } | ForEach-Object { Stop-Process -Id $_.ProcessId -Force }\"",
+	"cmdReadFile_linux":           "{{.Sudo}}cat '%s'",
+	"cmdReadFile_windows":         "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command \"Get-Content -Path '%s'\"",
+	"cmdCheckExtract_linux":       "command -v tar && command -v gzip",
+	"cmdCheckExtract_windows":     "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command \"if (Get-Command Expand-Archive -ErrorAction SilentlyContinue) { exit 0 } else { exit 1 }\"",
+	"cmdExtract_linux":            "tar -xzf '%[2]s' -C '%[1]s' && rm -f '%[2]s'",
+	"cmdExtract_windows":          "powershell.exe -ExecutionPolicy Bypass -OutputFormat Text -Command \"$ErrorActionPreference = 'Stop'; Expand-Archive -Path '%[2]s' -DestinationPath '%[1]s' -Force; Remove-Item -Force '%[2]s'\"",
 }
 
+// killTimeout is how long to wait for salt-call to exit after it has been stopped.
+const killTimeout = 30 * time.Second
+
 type Config struct {
 	// Embedded Packer fields (e.g. Communicator, PauseBefore, etc.)
 	common.PackerConfig `mapstructure:",squash"`
@@ -67,17 +115,93 @@ type Config struct {
 	// your local system where Packer is executing. State files are applied in the order
 	// in which they appear in the parameter. This option is exclusive
 	// with `state_tree`.
+	//
+	// Each file is uploaded to the `state_directory` at its path relative to `state_files_root`, and is
+	// applied using the corresponding SLS name. For example, with a `state_files_root` of `states`, the file
+	// `states/web/nginx.sls` is applied as `web.nginx` and `states/web/init.sls` is applied as `web`. Without
+	// `state_files_root`, the same files are applied as `states.web.nginx` and `states.web`.
+	// Files must have a `.sls` extension, and the directories and file names within `state_files_root`
+	// must not contain a `.` other than in the extension.
 	StateFiles []string `mapstructure:"state_files"`
 
+	// The directory on your local system that corresponds to the root of the Salt file tree, used to
+	// determine the upload path and SLS name of each file in `state_files`. All state files must be within
+	// this directory. If not specified, the current working directory is used, so files outside of it
+	// require this setting.
+	StateFilesRoot string `mapstructure:"state_files_root"`
+
+	// How the files in `state_files`, or the states in `states`, are applied. Supported values are:
+	//
+	// `separate` - Apply each state with a separate `salt-call` run. This is the default.
+	// `combined` - Apply all of the states with a single `salt-call` run, for example
+	// `state.apply web,db,users`. States are still applied in the order in which the files are listed,
+	// requisites can refer to states in other files, and grains and pillar data are only loaded once.
+	// Each state result is attributed to the file that it was defined in.
+	ApplyMode string `mapstructure:"apply_mode"`
+
 	// A path to the complete Salt state tree on your local system to be copied to the remote machine as the
 	// `state_directory`. The structure of the state tree is flexible, however the use of this option assumes
 	// that a `top.sls` file is present at the top of the state tree. The plugin assumes that Salt will evaluate
 	// the `top.sls` file and match expressions to determine which individual states should be applied. This action
-	// is referred to as a "highstate". This option is exclusive with `state_files`.
+	// is referred to as a "highstate". Specific states within the state tree can be applied instead of a
+	// highstate by setting `states`. This option is exclusive with `state_files`.
 	//
 	// For more details about states and highstates, refer to the [Salt documentation](https://docs.saltproject.io/en/latest/topics/tutorials/starting_states.html).
 	StateTree string `mapstructure:"state_tree"`
 
+	// The SLS names of states within `state_tree` to be applied, for example `["base", "web.nginx"]`,
+	// instead of a highstate. This allows a single state tree to be shared by many builds without a
+	// `top.sls` file for each of them. States are applied in the order in which they appear in the
+	// parameter. Each name must correspond to a `<name>.sls` file or a `<name>/init.sls` file within
+	// `state_tree`. This option requires `state_tree`.
+	States []string `mapstructure:"states"`
+
+	// States defined inline, supplied as a map of SLS names to state content, so that small states can be
+	// kept within a template instead of in separate files. Each value is either SLS content, which is
+	// rendered by Salt in the same way as a state file, or an object produced by the `jsonencode` function,
+	// which is rendered as YAML without Jinja. Each state is written to the `state_directory` as
+	// `<name>.sls`, with any `.` in the name replaced by `/`, and can be referred to by its name in `state`
+	// blocks. Unless `state` blocks are used, inline states are applied in lexical order of their names,
+	// after any states from `state_files` or `state_tree`.
+	//
+	// For example:
+	//
+	// ```hcl
+	// inline_states = {
+	//   motd = <<-EOT
+	//     /etc/motd:
+	//       file.managed:
+	//         - contents: Built by Packer on {{ grains['osfinger'] }}
+	//     EOT
+	//   "packages.tools" = jsonencode({
+	//     tools = { "pkg.installed" = [{ pkgs = ["curl", "jq"] }] }
+	//   })
+	// }
+	// ```
+	InlineStates map[string]string `mapstructure:"inline_states"`
+
+	// A sequence of Salt steps to run, each with its own `salt-call` run. All steps share a single upload
+	// of the state and pillar files, so a number of steps can be described by one provisioner without
+	// uploading the state tree more than once. Steps are run in the order in which they appear, and
+	// replace the states that would otherwise be applied from `state_files` or `state_tree`. This option
+	// is exclusive with `states`. See the [State Blocks](#state-blocks) section for the available settings.
+	//
+	// For example:
+	//
+	// ```hcl
+	// state {
+	//   name = "base"
+	// }
+	//
+	// state {
+	//   name    = "web.nginx"
+	//   pillar  = { port = "8080" }
+	//   retries = 2
+	//   only_os = ["linux"]
+	// }
+	// ```
+	Steps []StateConfig `mapstructure:"state"`
+
 	// Directory where files will be uploaded to on the target system.
 	// NOTE: Deprecated. Use state_directory instead.
 	StagingDir string `mapstructure:"staging_directory"`
@@ -101,16 +225,31 @@ type Config struct {
 	StateDir string `mapstructure:"state_directory"`
 
 	// The individual pillar files to be used by Salt. These files must exist on
-	// the local system where Packer is executing. Individual pillar files must be referenced
-	// directly by state files unless a 'top.sls' file is included. This option is exclusive
-	// with `pillar_tree`.
+	// the local system where Packer is executing. Unless a `top.sls` file is included, a pillar
+	// top file is generated that assigns each of the pillar files with a `.sls` extension to the
+	// minion in the order in which they are listed, so that values in later files override those
+	// in earlier files.
+	//
+	// Each file is uploaded to the `pillar_directory` at its path relative to `pillar_files_root`, and is
+	// assigned using the corresponding SLS name. Pillar files with a `.sls` extension must have a valid
+	// SLS name, so their directories and file names must not contain a `.` other than in the extension.
+	//
+	// When used together with `pillar_tree`, the pillar files are uploaded over the pillar tree,
+	// replacing any files with the same path. If the pillar tree has a `top.sls` file, the generated
+	// top file includes it and assigns the pillar files after the pillar from the pillar tree.
 	PillarFiles []string `mapstructure:"pillar_files"`
 
+	// The directory on your local system that corresponds to the root of the pillar tree, used to
+	// determine the upload path and SLS name of each file in `pillar_files`. All pillar files must be
+	// within this directory. If not specified, `pillar_tree` is used, or the current working directory
+	// if `pillar_tree` is not set.
+	PillarFilesRoot string `mapstructure:"pillar_files_root"`
+
 	// A path to the complete Salt pillar tree on your local system to be copied to the remote machine as the
 	// `pillar_directory`. The structure of the pillar tree is flexible, however the use of this option assumes
 	// that a `top.sls` file is present at the top of the pillar tree. The plugin assumes that Salt will evaluate
 	// the `top.sls` file and match expressions to determine which individual pillars should be applied.
-	// This option is exclusive with `pillar_files`.
+	// This option can be combined with `pillar_files`.
 	//
 	// For more details about pillars, refer to the [Salt documentation](https://docs.saltproject.io/salt/user-guide/en/latest/topics/pillar.html).
 	PillarTree string `mapstructure:"pillar_tree"`
@@ -133,6 +272,156 @@ type Config struct {
 	// characters causing issues when this plugin is executed on a Linux system.
 	PillarDir string `mapstructure:"pillar_directory"`
 
+	// Additional Salt file roots, each of which declares the local directories for a Salt environment
+	// (saltenv). Each directory is uploaded to its own directory on the target system, and a minion
+	// configuration declaring them is generated for `salt-call`. The `state_directory` is always the
+	// first file root of the `base` environment. This allows formulas kept in separate directories, and
+	// multiple Salt environments such as `base`, `dev` and `prod`, to be used. See the
+	// [File and Pillar Roots](#file-and-pillar-roots) section for the available settings.
+	//
+	// For example:
+	//
+	// ```hcl
+	// file_roots {
+	//   saltenv = "base"
+	//   paths   = ["salt", "formulas/nginx-formula"]
+	// }
+	//
+	// file_roots {
+	//   saltenv = "dev"
+	//   paths   = ["salt-dev"]
+	// }
+	// ```
+	FileRoots []RootsConfig `mapstructure:"file_roots"`
+
+	// Additional Salt pillar roots, declared in the same way as `file_roots`. When `pillar_files` or
+	// `pillar_tree` is used, the `pillar_directory` is the first pillar root of the `base` environment.
+	PillarRoots []RootsConfig `mapstructure:"pillar_roots"`
+
+	// The Salt environment that states are applied from. The `saltenv` of a `state` block takes
+	// precedence. If not specified, the default of Salt is used.
+	Saltenv string `mapstructure:"saltenv"`
+
+	// The Salt environment that pillar data is compiled from. If not specified, pillar data from all
+	// environments is used, which is the default of Salt.
+	Pillarenv string `mapstructure:"pillarenv"`
+
+	// A path to a Salt minion configuration file on your local system, used for `salt-call` in place of
+	// the minion configuration of the target system. The file and pillar roots needed by the provisioner
+	// are added to the configuration, together with any settings from `minion_config`, which take
+	// precedence over the settings in the file. Any file or pillar roots declared in the file are kept.
+	MinionConfigFile string `mapstructure:"minion_config_file"`
+
+	// Salt minion configuration settings, supplied as a map, that are used for `salt-call`. Lists and objects
+	// can be supplied using the `jsonencode` function, and the values `true` and `false` are passed to Salt
+	// as booleans. All other values, including numbers, are passed as strings. These settings take precedence over
+	// `minion_config_file`, except that any `file_roots` and `pillar_roots` are merged with the roots
+	// needed by the provisioner.
+	//
+	// For example:
+	//
+	// ```hcl
+	// minion_config = {
+	//   id                = "web-image"
+	//   file_ignore_regex = jsonencode(["/\\.git($|/)"])
+	//   grains            = jsonencode({ role = "web" })
+	// }
+	// ```
+	MinionConfig map[string]string `mapstructure:"minion_config"`
+
+	// Salt formulas to fetch from git repositories on the system where Packer is executing. Each formula is
+	// fetched into the Packer cache directory, uploaded to the target system and added to the `base` file
+	// root after any other roots, so states from the state tree and `file_roots` take precedence. The
+	// commit that each formula resolves to is shown in the provisioner output. Formulas do not provide a
+	// top file, so when they are the only source of states, the states to apply must be named with `states`
+	// or `state` blocks. A formula that contains a symbolic link to a path outside of the formula is rejected.
+	//
+	// For example:
+	//
+	// ```hcl
+	// formulas {
+	//   url = "https://github.com/saltstack-formulas/nginx-formula.git"
+	//   ref = "v2.8.1"
+	// }
+	//
+	// formulas {
+	//   url    = "file:///srv/git/platform.git"
+	//   ref    = "main"
+	//   subdir = "formulas/users"
+	// }
+	// ```
+	Formulas []FormulaConfig `mapstructure:"formulas"`
+
+	// A path to a lock file on your local system that pins the commit of each formula. Formulas
+	// that are not in the lock file are added to it with the commit that they resolve to. If the ref
+	// of a formula resolves to a different commit than the one in the lock file, the build fails.
+	// To update a formula, remove its entry from the lock file.
+	FormulasLock string `mapstructure:"formulas_lock"`
+
+	// A path to a directory on your local system that contains custom Salt modules in the directories used by
+	// Salt, such as `_modules`, `_states`, `_grains` and `_renderers`. The directory is uploaded to the target
+	// system and added to the `base` file root, and the modules are synced before any states are applied.
+	ExtensionModules string `mapstructure:"extension_modules"`
+
+	// If set to `true`, custom modules from the file roots, such as the `_modules` directory of the state tree,
+	// are synced with `saltutil.sync_all` before any states are applied. The modules that are synced are shown
+	// in the provisioner output. This is always done when `extension_modules` is set. By default this is set
+	// to `false`.
+	SyncModules bool `mapstructure:"sync_modules"`
+
+	// The types of custom modules to sync, for example `["modules", "states"]`, using the corresponding
+	// `saltutil.sync_*` functions instead of `saltutil.sync_all`. Supported values are `beacons`, `clouds`,
+	// `engines`, `executors`, `grains`, `log_handlers`, `matchers`, `modules`, `output`, `pillar`,
+	// `proxymodules`, `renderers`, `returners`, `sdb`, `serializers`, `states`, `thorium` and `utils`.
+	SyncTypes []string `mapstructure:"sync_types"`
+
+	// If set to `true`, the state and pillar files on your local system are checked before the build starts.
+	// Each `.sls` file in `state_files`, `state_tree`, `file_roots`, `inline_states`, `pillar_files`,
+	// `pillar_tree` and `pillar_roots` is parsed as YAML after Jinja statements and comments are removed
+	// and Jinja expressions are replaced with a placeholder, and any syntax errors are reported with the
+	// file and line. Files that use a renderer other than `jinja`, `yaml`, `json` or `gpg` are not checked.
+	// The `include` targets of each state must exist in the state files, inline states or state tree,
+	// unless they use Jinja or refer to another saltenv, and a `top.sls` file must exist in the `state_tree`
+	// when a highstate is applied. By default this is set to `false`.
+	ValidateSLS bool `mapstructure:"validate_sls"`
+
+	// If set to `true`, the states of each step are rendered on the target system with `state.show_sls`, or
+	// `state.show_highstate` for a highstate, before any states are applied. The same directories, saltenv
+	// and pillar data are used as when the states are applied. If any states cannot be rendered, for example
+	// because of a Jinja or YAML error, the errors reported by Salt are shown and the build fails before any
+	// changes are made. By default this is set to `false`.
+	RenderCheck bool `mapstructure:"render_check"`
+
+	// A path on your local system to write the state data rendered by `render_check` to, as JSON. This
+	// records the states that are applied to the image. Any missing parent directories are created.
+	RenderOutput string `mapstructure:"render_output"`
+
+	// Patterns of files to exclude when uploading directories such as `state_tree` and `pillar_tree`, using
+	// the syntax of a `.gitignore` file. Patterns are matched against paths relative to the uploaded directory.
+	// A `.saltignore` file at the root of an uploaded directory is also read, and `exclude` is applied after it.
+	//
+	// For example:
+	//
+	// ```hcl
+	// exclude = [".git/", "__pycache__/", "*.swp", "tests/"]
+	// ```
+	Exclude []string `mapstructure:"exclude"`
+
+	// Patterns of files to upload even though they are excluded by `exclude` or a `.saltignore` file, using
+	// the same syntax. Files within an excluded directory cannot be included unless the directory itself is.
+	Include []string `mapstructure:"include"`
+
+	// The method used to upload state and pillar files to the target system. Supported values are:
+	//
+	// `files` - Each file is uploaded separately. This is the default.
+	// `archive` - The files of each directory, such as the state tree, are packed into a single `tar.gz`
+	// archive, or a `zip` archive for Windows, which is uploaded and extracted on the target system. This
+	// is much faster when there are many files, especially over WinRM. The bytes saved by compression are
+	// shown in the output, together with an estimate of the time saved for `state_files` and `pillar_files`,
+	// which are otherwise uploaded one file at a time. If `tar` and `gzip` on Linux or the `Expand-Archive`
+	// PowerShell command on Windows are not available, each file is uploaded separately.
+	UploadStrategy string `mapstructure:"upload_strategy"`
+
 	// If set to `true`, the contents uploaded to the target system will be removed after
 	// applying Salt states. By default this is set to `false`.
 	Clean bool `mapstructure:"clean"`
@@ -158,9 +447,31 @@ type Config struct {
 	// cmd.run:
 	//  - name: echo {{ config_value }}
 	// ```
+	//
+	// Environment variables are written to a file in a private directory on the target system,
+	// `/tmp/packer-provisioner-salt-env` on Linux or `C:/Windows/Temp/packer-provisioner-salt-env` on
+	// Windows, that can only be read by root (or by SYSTEM and Administrators on Windows). The file is
+	// loaded before `salt-call` runs and the directory is removed as soon as Salt has finished, so the
+	// values are not visible in the process list of the target system.
 	EnvVars []string `mapstructure:"environment_vars"`
 
+	// A map of environment variables that will be made available to the Salt process when it
+	// is executed. This option can be used instead of, or together with, `environment_vars`.
+	// Values in `env` take precedence over values with the same name in `environment_vars`.
+	//
+	// For example:
+	//
+	// ```hcl
+	// env = {
+	//   SECRET_VALUE = var.build_secret
+	//   CONFIG_VALUE = var.config_value
+	// }
+	// ```
+	Env map[string]string `mapstructure:"env"`
+
 	// Format string for environment variables. Default: "VARNAME='VARVALUE' ".
+	// If set, environment variables are passed on the `salt-call` command line
+	// using this format instead of being written to a file.
 	// NOTE: Deprecated.
 	EnvVarFormat string `mapstructure:"env_var_format"`
 
@@ -168,12 +479,259 @@ type Config struct {
 	// The default for salt-call is 'warning', however this plugin uses the default of 'error'.
 	// Possible valid values for salt-call are: all, garbage, trace, debug, info, warning, error, quiet.
 	LogLevel string `mapstructure:"log_level"`
+
+	// The command used to run `salt-call`, as a template. This overrides the default command, for example to
+	// pass options to `sudo` or to run `salt-call` through another program. The template can use these variables:
+	//
+	// `{{.Sudo}}` - `sudo `, or the command that passes `sudo_password` to `sudo`. Empty on Windows or if
+	// `prevent_sudo` is set.
+	// `{{.Vars}}` - The prefix that makes the environment variables available to `salt-call`.
+	// `{{.SaltCall}}` - The value of `salt_call_path`.
+	// `{{.LogLevel}}` - The value of `log_level`.
+	// `{{.LogFile}}` - The path of the `salt-call` log file on the target system.
+	// `{{.StateDir}}` - The value of `state_directory`.
+	// `{{.PillarDir}}` - The value of `pillar_directory`.
+	// `{{.ExtraArgs}}` - The arguments that select the file and pillar roots or the minion configuration.
+	// `{{.Target}}` - The Salt function to run and its arguments, for example `state.apply web test=True`.
+	//
+	// The default on Linux is:
+	//
+	// ```
+	// {{.Sudo}}{{.Vars}}'{{.SaltCall}}' --local --retcode-passthrough --out=json --log-level={{.LogLevel}} --log-file={{.LogFile}} --log-file-level=info {{.ExtraArgs}} {{.Target}}
+	// ```
+	//
+	// On Windows, `{{.Sudo}}` is omitted and `{{.SaltCall}}` is enclosed in double quotes, so that a path such as
+	// `C:\Program Files\Salt Project\Salt\salt-call.exe` can be used.
+	//
+	// The command must keep the `--local`, `--retcode-passthrough` and `--out=json` options so that the results
+	// of states can be read.
+	ExecuteCommand string `mapstructure:"execute_command"`
+
+	// The path of `salt-call` on the target system, for example `/opt/saltstack/salt/bin/salt-call`. If not
+	// specified, `salt-call` is found using the path.
+	SaltCallPath string `mapstructure:"salt_call_path"`
+
+	// If set to `true`, commands on Linux are run without `sudo`, for example when connecting as root to a
+	// container that does not have `sudo` installed. By default this is set to `false`.
+	PreventSudo bool `mapstructure:"prevent_sudo"`
+
+	// The password passed to `sudo` on Linux when it requires one. The password is not shown in the output.
+	SudoPassword string `mapstructure:"sudo_password"`
+
+	// If set to `true`, the provisioner will make sure that Salt is installed on the target system before
+	// any states are applied. Installation is skipped if `salt-call` is already present and, when `salt_version`
+	// is set, reports a matching version. By default this is set to `false`.
+	InstallSalt bool `mapstructure:"install_salt"`
+
+	// The version of Salt to install when `install_salt` is set, for example `3007.1`. A major version such
+	// as `3006` will match any release in that series. If not specified, the latest available release is
+	// installed and any existing installation is accepted as is.
+	SaltVersion string `mapstructure:"salt_version"`
+
+	// The method used to install Salt when `install_salt` is set. Supported values are:
+	//
+	// `onedir` - Install the Salt onedir packages. This is the default.
+	// `bootstrap` - Install Salt using the `stable` install type of the Salt bootstrap script.
+	// `package` - Install the local package files listed in `install_packages`.
+	//
+	// The `onedir` and `bootstrap` methods download the bootstrap script onto the target system unless
+	// `bootstrap_script` is set. On Windows targets both methods use `bootstrap-salt.ps1`.
+	InstallMethod string `mapstructure:"install_method"`
+
+	// A path to a copy of the Salt bootstrap script on your local system (`bootstrap-salt.sh` for Linux
+	// or `bootstrap-salt.ps1` for Windows). When set, the script is uploaded to the target system instead
+	// of being downloaded, which allows Salt to be installed on systems without internet access.
+	BootstrapScript string `mapstructure:"bootstrap_script"`
+
+	// Additional arguments passed to the Salt bootstrap script after the install type and version.
+	BootstrapArgs string `mapstructure:"bootstrap_args"`
+
+	// The local package files used to install Salt when `install_method` is `package`. These files must
+	// exist on your local system where Packer is executing and are uploaded to the target system before
+	// being installed. Supported package types are `.rpm` and `.deb` for Linux and `.msi` and `.exe` for
+	// Windows. For Linux targets all of the packages are installed with a single command so that
+	// dependencies between them (for example `salt` and `salt-minion`) are resolved.
+	InstallPackages []string `mapstructure:"install_packages"`
+
+	// Controls how the results of individual states are displayed after salt-call has run. This option
+	// mirrors the `state_output` setting of Salt. Supported values are:
+	//
+	// `full` - Display the full details of each state. This is the default.
+	// `terse` - Display a single line for each state.
+	// `mixed` - Display a single line for each state unless it failed, in which case the full details are shown.
+	// `changes` - Display the full details of states that failed or made changes, and a single line for other states.
+	//
+	// A summary of the number of succeeded, changed and failed states, the total run time and the slowest
+	// states is always displayed. Failed states are reported as errors together with their comments.
+	StateOutput string `mapstructure:"state_output"`
+
+	// If set to `true`, states that succeeded without making any changes are included in the state output.
+	// By default this is set to `false` and only states that made changes or failed are displayed.
+	StateVerbose bool `mapstructure:"state_verbose"`
+
+	// One or more reports of the state results to be written to your local system where Packer is executing.
+	// Reports are rewritten after each salt-call run, so that a report is still available if a run fails.
+	// Each `salt-call` run, such as each entry in `state_files` or each `state` block, is reported separately.
+	//
+	// For example:
+	//
+	// ```hcl
+	// report {
+	//   path   = "reports/salt-junit.xml"
+	//   format = "junit"
+	// }
+	// ```
+	Reports []ReportConfig `mapstructure:"report"`
+
+	// If set to `true`, states are applied with `test=True` so that Salt reports what would change
+	// without making any changes to the target system. The state output, summary and any reports
+	// are produced as normal. By default this is set to `false`.
+	TestMode bool `mapstructure:"test_mode"`
+
+	// If set to `true`, the build fails if any state reports changes. When combined with `test_mode`
+	// this fails the build if any state would make changes, which can be used to detect drift between
+	// an image and a state tree. By default this is set to `false`.
+	FailOnChanges bool `mapstructure:"fail_on_changes"`
+
+	// If set to `true`, the states are run a second time after they have been applied and the build fails
+	// if any state still reports changes. The IDs of states that are not idempotent are listed in the error.
+	// This option has no effect when `test_mode` is set. By default this is set to `false`.
+	VerifyIdempotency bool `mapstructure:"verify_idempotency"`
+
+	// How the second run is performed when `verify_idempotency` is set. Supported values are:
+	//
+	// `apply` - Apply the states again. This is the default.
+	// `test` - Run the states with `test=True`, so that no further changes are made to the target system.
+	IdempotencyMode string `mapstructure:"idempotency_mode"`
+
+	// The maximum amount of time that all `salt-call` runs, including any idempotency verification,
+	// may take in total, for example `1h30m`. If the timeout is reached, the running `salt-call`
+	// process is killed and the build fails. By default there is no timeout.
+	ExecutionTimeout time.Duration `mapstructure:"execution_timeout"`
+
+	// The maximum amount of time that each individual `salt-call` run may take, for example `20m`.
+	// When `state_files` lists more than one file, each file is applied by a separate run. Syncing modules
+	// with `sync_modules` and rendering states with `render_check` also use separate runs. If the timeout
+	// is reached, the running `salt-call` process is killed, the state that was running at the time is
+	// reported and the build fails. By default there is no timeout. A `timeout` set on the provisioner
+	// itself is handled by Packer and applies to the whole provisioner instead.
+	StateTimeout time.Duration `mapstructure:"state_timeout"`
+
+	// Pillar data to be passed to Salt, supplied as a map. Lists and objects can be supplied using the
+	// `jsonencode` function, allowing Packer variables and locals to be used in Salt without being flattened
+	// to strings, and numbers within them keep their type. The values `true` and `false` are passed as
+	// booleans. All other values, including numbers such as `3.10`, are passed as strings. The data is serialized to JSON and
+	// passed to `salt-call` using the `pillar` argument, so it is merged with, and takes precedence over,
+	// any pillar data from `pillar_files` or `pillar_tree`. As the data is part of the `salt-call` command
+	// line, it can be seen by other processes on the target system while Salt is running.
+	//
+	// For example:
+	//
+	// ```hcl
+	// pillar = {
+	//   environment = "production"
+	//   users       = jsonencode([ "alice", "bob" ])
+	//   nginx       = jsonencode({
+	//     worker_processes = 4
+	//     gzip             = true
+	//   })
+	// }
+	// ```
+	// These values can then be consumed within Salt states, for example `{{ pillar['nginx']['worker_processes'] }}`.
+	Pillar map[string]string `mapstructure:"pillar"`
+
+	// The names of environment variables in `environment_vars` whose values are sensitive. These values are
+	// masked in all output from the provisioner, including the output of Salt and any reports. The values of
+	// Packer sensitive variables are always masked.
+	SensitiveEnvVars []string `mapstructure:"sensitive_environment_vars"`
+
+	// The keys of entries in `pillar` whose values are sensitive. These values, including any individual
+	// strings within lists or objects, are masked in all output from the provisioner, including the output
+	// of Salt and any reports. Numbers and booleans within lists or objects are not masked. Values that
+	// must not be seen by other processes on the target system should be supplied with `pillar_files` or
+	// `pillar_tree` instead.
+	SensitivePillarKeys []string `mapstructure:"sensitive_pillar_keys"`
+}
+
+// A report of state results written to the local system.
+type ReportConfig struct {
+	// The local path of the report file. Any missing parent directories are created.
+	Path string `mapstructure:"path" required:"true"`
+
+	// The format of the report. Supported values are `json`, `junit`, `markdown` and `html`.
+	// If not specified, this value defaults to `json`.
+	Format string `mapstructure:"format"`
+}
+
+// The local directories of a Salt file or pillar root for a single Salt environment.
+type RootsConfig struct {
+	// The Salt environment that the directories belong to, for example `base`.
+	Saltenv string `mapstructure:"saltenv" required:"true"`
+
+	// The local directories to use as roots for the Salt environment, in order of precedence.
+	Paths []string `mapstructure:"paths" required:"true"`
+}
+
+// A Salt formula fetched from a git repository.
+type FormulaConfig struct {
+	// The URL of the git repository, which can also be a `file://` URL or a path on your local system.
+	URL string `mapstructure:"url" required:"true"`
+
+	// The branch, tag or commit to fetch. If not specified, the default branch of the repository is used.
+	Ref string `mapstructure:"ref"`
+
+	// The directory within the repository to use as the file root, for example a directory
+	// that contains several formulas. If not specified, the root of the repository is used.
+	Subdir string `mapstructure:"subdir"`
+}
+
+// A step that applies Salt states with a single `salt-call` run.
+type StateConfig struct {
+	// The SLS name of the state to apply, for example `web.nginx`. Several states can be applied
+	// together by separating their names with commas. When `state_tree` is used, each state must exist
+	// in the state tree, and when `state_files` is used, each state must be one of the state files.
+	// If not specified, a highstate is applied.
+	Name string `mapstructure:"name"`
+
+	// Pillar data for this step, supplied in the same way as the `pillar` option. Values are merged
+	// with, and take precedence over, the values of the `pillar` option.
+	Pillar map[string]string `mapstructure:"pillar"`
+
+	// The Salt environment to apply the state from. If not specified, the default of Salt is used.
+	Saltenv string `mapstructure:"saltenv"`
+
+	// If set to `true`, the state is applied with `test=True`, or if set to `false` it is applied normally.
+	// If not specified, the value of `test_mode` is used.
+	Test config.Trilean `mapstructure:"test"`
+
+	// The number of times to retry the step if it fails. By default a failed step is not retried.
+	Retries int `mapstructure:"retries"`
+
+	// The maximum amount of time that each `salt-call` run for this step may take, for example `20m`.
+	// This also applies when the states of the step are rendered by `render_check`. If not specified, the
+	// value of `state_timeout` is used.
+	Timeout time.Duration `mapstructure:"timeout"`
+
+	// The target operating systems that the step runs on, from `linux` and `windows`. If not specified,
+	// the step runs on all operating systems.
+	OnlyOS []string `mapstructure:"only_os"`
+
+	// If set to `true`, a failure of this step is reported but does not fail the build.
+	// By default this is set to `false`.
+	IgnoreFailure bool `mapstructure:"ignore_failure"`
 }
 
 type Provisioner struct {
 	config        Config
-	stateFiles    []string
-	pillarFiles   []string
+	stateFiles    []slsFile
+	pillarFiles   []slsFile
+	treeStates    []slsFile
+	configDir     string
+	inlineStates  []slsFile
+	formulas      []formula
+	stateRuns     []*stateRun
+	inlineEnvVars bool
+	extractor     *bool
 	generatedData map[string]interface{}
 }
 
@@ -189,6 +747,11 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		PluginType:         "salt",
 		Interpolate:        true,
 		InterpolateContext: &p.config.ctx,
+		InterpolateFilter: &interpolate.RenderFilter{
+			Exclude: []string{
+				"execute_command",
+			},
+		},
 	}, raws...)
 	if err != nil {
 		return err
@@ -215,6 +778,8 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 	// TODO: p.config.EnvVarFormat to be deprecated
 	if p.config.EnvVarFormat == "" {
 		p.config.EnvVarFormat = p.getConfig("configEnvFormat")
+	} else {
+		p.inlineEnvVars = true
 	}
 	// TODO: p.config.StagingDir to be deprecated
 	if p.config.StateDir == "" {
@@ -227,16 +792,35 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 	if p.config.PillarDir == "" {
 		p.config.PillarDir = p.getConfig("configPillarDir")
 	}
+	if p.config.InstallMethod == "" {
+		p.config.InstallMethod = "onedir"
+	} else {
+		p.config.InstallMethod = strings.ToLower(p.config.InstallMethod)
+	}
 
 	// Validate exclusive options
 	if len(p.config.StateFiles) != 0 && p.config.StateTree != "" {
 		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("either state_files or state_tree can be specified, not both"))
 	}
-	if len(p.config.StateFiles) == 0 && p.config.StateTree == "" {
-		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("either state_files or state_tree must be specified"))
+	if len(p.config.StateFiles) == 0 && p.config.StateTree == "" && len(p.config.InlineStates) == 0 && len(p.config.FileRoots) == 0 && len(p.config.Formulas) == 0 {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("one of state_files, state_tree, inline_states, file_roots or formulas must be specified"))
 	}
-	if len(p.config.PillarFiles) != 0 && p.config.PillarTree != "" {
-		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("either pillar_files or pillar_tree can be specified, not both"))
+	if len(p.config.States) != 0 && p.config.StateTree == "" && len(p.config.FileRoots) == 0 && len(p.config.Formulas) == 0 {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("states can only be specified with state_tree, file_roots or formulas"))
+	}
+	if len(p.config.States) != 0 && len(p.config.Steps) != 0 {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("either states or state blocks can be specified, not both"))
+	}
+	if len(p.config.Formulas) != 0 && len(p.config.StateFiles) == 0 && p.config.StateTree == "" && len(p.config.InlineStates) == 0 && len(p.config.FileRoots) == 0 {
+		// Formulas do not provide a top file, so there is no highstate to apply
+		if len(p.config.States) == 0 && len(p.config.Steps) == 0 {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("states or state blocks must be specified when formulas are the only source of states"))
+		}
+		for i, step := range p.config.Steps {
+			if step.Name == "" {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("state %d: name must be specified when formulas are the only source of states", i+1))
+			}
+		}
 	}
 
 	// Validate any supplied environment variables
@@ -247,29 +831,87 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 				fmt.Errorf("environment variable not in format 'key=value': %s", kv))
 		}
 	}
+	for k := range p.config.Env {
+		if k == "" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("env: environment variable names cannot be empty"))
+		}
+	}
+
+	// Validate sensitive values
+	for _, err := range p.validateSecretConfig() {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
 
 	// Validate supplied arrays of files
+	var stateFiles, pillarFiles []string
 	for _, f := range p.config.StateFiles {
 		if err := validateFileConfig(f, "state_files"); err != nil {
 			errs = packersdk.MultiErrorAppend(errs, err)
 		} else {
-			p.stateFiles = append(p.stateFiles, f)
+			stateFiles = append(stateFiles, f)
 		}
 	}
 	for _, f := range p.config.PillarFiles {
 		if err := validateFileConfig(f, "pillar_files"); err != nil {
 			errs = packersdk.MultiErrorAppend(errs, err)
 		} else {
-			p.pillarFiles = append(p.pillarFiles, f)
+			pillarFiles = append(pillarFiles, f)
+		}
+	}
+	if p.config.StateFilesRoot != "" {
+		if err := validateDirConfig(p.config.StateFilesRoot, "state_files_root"); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+	}
+	if p.config.PillarFilesRoot != "" {
+		if err := validateDirConfig(p.config.PillarFilesRoot, "pillar_files_root"); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, err)
 		}
 	}
 
+	// Resolve upload paths and SLS names
+	var resolveErrs []error
+	p.stateFiles, resolveErrs = resolveStateFiles(stateFiles, p.config.StateFilesRoot)
+	for _, err := range resolveErrs {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+	p.pillarFiles, resolveErrs = resolvePillarFiles(pillarFiles, p.config.PillarFilesRoot, p.config.PillarTree)
+	for _, err := range resolveErrs {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+	p.inlineStates, resolveErrs = p.resolveInlineStates()
+	for _, err := range resolveErrs {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+
 	// Vaildate supplied file trees
 	if p.config.StateTree != "" {
 		if err := validateDirConfig(p.config.StateTree, "state_tree"); err != nil {
 			errs = packersdk.MultiErrorAppend(errs, err)
 		}
 	}
+	for _, err := range validateRootsConfig(p.config.FileRoots, "file_roots") {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+	for _, err := range validateRootsConfig(p.config.PillarRoots, "pillar_roots") {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+	if p.config.MinionConfigFile != "" {
+		if err := validateFileConfig(p.config.MinionConfigFile, "minion_config_file"); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+	}
+	if _, err := p.createMinionConfig(); err != nil {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+	for _, err := range validateFormulaConfig(p.config.Formulas) {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+	if p.config.FormulasLock != "" {
+		if info, err := os.Stat(p.config.FormulasLock); err == nil && info.IsDir() {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("formulas_lock: %s must be a file", p.config.FormulasLock))
+		}
+	}
 	if p.config.PillarTree != "" {
 		if err := validateDirConfig(p.config.PillarTree, "pillar_tree"); err != nil {
 			errs = packersdk.MultiErrorAppend(errs, err)
@@ -296,10 +938,136 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		p.config.LogLevel = "error"
 	}
 
+	// Validate state output
+	switch p.config.StateOutput {
+	case "":
+		p.config.StateOutput = "full"
+	case "full", "terse", "mixed", "changes":
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("permitted value for state_output is one of: full, terse, mixed, changes"))
+	}
+
+	// Validate apply mode
+	switch p.config.ApplyMode {
+	case "":
+		p.config.ApplyMode = "separate"
+	case "separate", "combined":
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("permitted value for apply_mode is one of: separate, combined"))
+	}
+
+	// Validate idempotency verification
+	switch p.config.IdempotencyMode {
+	case "":
+		p.config.IdempotencyMode = "apply"
+	case "apply", "test":
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("permitted value for idempotency_mode is one of: apply, test"))
+	}
+
+	// Validate module syncing
+	if p.config.ExtensionModules != "" {
+		if err := validateExtensionModules(p.config.ExtensionModules); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+	}
+	for _, t := range p.config.SyncTypes {
+		if !syncTypes[t] {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("sync_types: %s is not a supported module type", t))
+		}
+	}
+
+	// Validate salt-call command
+	if p.config.SaltCallPath == "" {
+		p.config.SaltCallPath = "salt-call"
+	}
+	if p.config.PreventSudo && p.config.SudoPassword != "" {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("either prevent_sudo or sudo_password can be specified, not both"))
+	}
+	if p.config.ExecuteCommand != "" {
+		p.config.ctx.Data = &saltCallTemplate{}
+		if _, err := interpolate.Render(p.config.ExecuteCommand, &p.config.ctx); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("execute_command: error parsing template: %s", err))
+		}
+	}
+
+	// Validate render check
+	if p.config.RenderOutput != "" && !p.config.RenderCheck {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("render_output can only be specified with render_check"))
+	}
+
+	// Validate upload exclusions
+	for _, err := range validateIgnorePatterns(p.config.Exclude, "exclude") {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+	for _, err := range validateIgnorePatterns(p.config.Include, "include") {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+
+	// Validate upload strategy
+	switch p.config.UploadStrategy {
+	case "":
+		p.config.UploadStrategy = "files"
+	case "files", "archive":
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("permitted value for upload_strategy is one of: files, archive"))
+	}
+
+	// Validate timeouts
+	if p.config.ExecutionTimeout < 0 {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("execution_timeout must not be negative"))
+	}
+	if p.config.StateTimeout < 0 {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("state_timeout must not be negative"))
+	}
+
+	// Validate state steps
+	for _, err := range p.validateStateSteps() {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+
+	// Resolve the states to apply. States provided by formulas are resolved once the formulas are fetched
+	if len(p.config.Formulas) == 0 {
+		for _, err := range p.resolveStates() {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+	}
+
+	// Validate state and pillar files
+	if p.config.ValidateSLS {
+		for _, err := range p.validateSLS() {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+	}
+
+	// Validate reports
+	for i := range p.config.Reports {
+		r := &p.config.Reports[i]
+		if r.Path == "" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("report: path must be specified"))
+		}
+		r.Format = strings.ToLower(r.Format)
+		if r.Format == "" {
+			r.Format = "json"
+		}
+		if _, ok := reportWriters[r.Format]; !ok {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("report: permitted value for format is one of: json, junit, markdown, html"))
+		}
+	}
+
+	// Validate Salt installation options
+	if p.config.InstallSalt {
+		for _, err := range p.validateInstallConfig() {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+	}
+
 	if errs != nil && len(errs.Errors) > 0 {
 		return errs
 	}
 
+	p.registerSecrets()
+
 	return nil
 }
 
@@ -308,21 +1076,51 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 // ----------------------------------------------------------------------------
 func (p *Provisioner) Provision(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, generatedData map[string]interface{}) error {
 	p.generatedData = generatedData
+	ui = &secretFilterUi{ui}
 	ui.Say(fmt.Sprintf("Salt provisioner plugin version: %s", version.PluginVersion))
 	ui.Say("Provisioning with Salt...")
 
+	// Fetch formulas and resolve the states that they provide
+	if len(p.config.Formulas) > 0 {
+		if err := p.fetchFormulas(ctx, ui); err != nil {
+			return fmt.Errorf("error fetching formulas: %s", err)
+		}
+		if errs := p.resolveStates(); len(errs) > 0 {
+			return &packersdk.MultiError{Errors: errs}
+		}
+	}
+
 	// Detect guest OS
-	p.config.TargetOS = p.detectGuestOS(comm, ui)
+	p.config.TargetOS = p.detectGuestOS(ctx, comm, ui)
+
+	// Clean up after the provisioner has finished, including after a cancellation
+	if p.config.Clean {
+		defer func() {
+			ui.Say("Cleaning up state and pillar directories...")
+			_ = p.removeDir(context.Background(), ui, comm, p.config.StateDir)
+			_ = p.removeDir(context.Background(), ui, comm, p.config.PillarDir)
+			if p.usesRootsDir() {
+				_ = p.removeDir(context.Background(), ui, comm, p.getConfig("configRootsDir"))
+			}
+		}()
+	}
+
+	// Install Salt
+	if p.config.InstallSalt {
+		if err := p.installSalt(ctx, ui, comm); err != nil {
+			return fmt.Errorf("error installing Salt: %s", err)
+		}
+	}
 
 	// Upload state tree or create directory for state files
 	if p.config.StateTree != "" {
 		ui.Say("Uploading State Tree...")
-		if err := p.uploadDir(ui, comm, p.config.StateDir, p.config.StateTree); err != nil {
+		if err := p.uploadDir(ctx, ui, comm, p.config.StateDir, p.config.StateTree); err != nil {
 			return fmt.Errorf("error uploading state_tree: %s", err)
 		}
 	} else {
 		ui.Say("Creating Salt state directory...")
-		if err := p.createDir(ui, comm, p.config.StateDir); err != nil {
+		if err := p.createDir(ctx, ui, comm, p.config.StateDir); err != nil {
 			return fmt.Errorf("error creating state directory: %s", err)
 		}
 	}
@@ -330,41 +1128,71 @@ func (p *Provisioner) Provision(ctx context.Context, ui packersdk.Ui, comm packe
 	// Upload pillar tree
 	if p.config.PillarTree != "" {
 		ui.Say("Uploading Pillar Tree...")
-		if err := p.uploadDir(ui, comm, p.config.PillarDir, p.config.PillarTree); err != nil {
+		if err := p.uploadDir(ctx, ui, comm, p.config.PillarDir, p.config.PillarTree); err != nil {
 			return fmt.Errorf("error uploading pillar_tree: %s", err)
 		}
 	}
 
+	// Upload file and pillar roots
+	if err := p.uploadRoots(ctx, ui, comm); err != nil {
+		return err
+	}
+
+	// Upload formulas
+	if len(p.formulas) > 0 {
+		if err := p.uploadFormulas(ctx, ui, comm); err != nil {
+			return fmt.Errorf("error uploading formulas: %s", err)
+		}
+	}
+
+	// Upload extension modules
+	if p.config.ExtensionModules != "" {
+		ui.Say("Uploading extension modules...")
+		if err := p.uploadDir(ctx, ui, comm, p.remoteRootDir("extension_modules", "base", 0), p.config.ExtensionModules); err != nil {
+			return fmt.Errorf("error uploading extension_modules: %s", err)
+		}
+	}
+
 	// Create directory for pillar files
 	if len(p.pillarFiles) > 0 {
 		ui.Say("Creating Salt pillar directory...")
-		if err := p.createDir(ui, comm, p.config.PillarDir); err != nil {
+		if err := p.createDir(ctx, ui, comm, p.config.PillarDir); err != nil {
 			return fmt.Errorf("error creating pillar directory: %s", err)
 		}
 	}
 
 	// Upload state files
 	if len(p.stateFiles) > 0 {
-		if err := p.uploadFiles(ui, comm, p.stateFiles, p.config.StateDir); err != nil {
+		if err := p.uploadFiles(ctx, ui, comm, p.stateFiles, p.config.StateDir); err != nil {
+			return err
+		}
+	}
+
+	// Upload inline states
+	if len(p.inlineStates) > 0 {
+		if err := p.uploadInlineStates(ctx, ui, comm); err != nil {
 			return err
 		}
 	}
 
 	// Upload pillar files
 	if len(p.pillarFiles) > 0 {
-		if err := p.uploadFiles(ui, comm, p.pillarFiles, p.config.PillarDir); err != nil {
+		if err := p.uploadFiles(ctx, ui, comm, p.pillarFiles, p.config.PillarDir); err != nil {
 			return err
 		}
+		if err := p.uploadPillarTop(ctx, ui, comm); err != nil {
+			return fmt.Errorf("error uploading pillar top file: %s", err)
+		}
 	}
 
-	if err := p.executeSalt(ui, comm); err != nil {
-		return fmt.Errorf("error executing Salt: %s", err)
+	if p.config.ExecutionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.ExecutionTimeout)
+		defer cancel()
 	}
 
-	if p.config.Clean {
-		ui.Say("Cleaning up state and pillar directories...")
-		_ = p.removeDir(ui, comm, p.config.StateDir)
-		_ = p.removeDir(ui, comm, p.config.PillarDir)
+	if err := p.executeSalt(ctx, ui, comm); err != nil {
+		return fmt.Errorf("error executing Salt: %s", err)
 	}
 
 	return nil
@@ -373,23 +1201,28 @@ func (p *Provisioner) Provision(ctx context.Context, ui packersdk.Ui, comm packe
 // ----------------------------------------------------------------------------
 // File and directory helper methods
 // ----------------------------------------------------------------------------
-func (p *Provisioner) uploadFiles(ui packersdk.Ui, comm packersdk.Communicator, sourceFiles []string, targetDir string) error {
+func (p *Provisioner) uploadFiles(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, sourceFiles []slsFile, targetDir string) error {
+	if p.config.UploadStrategy == "archive" {
+		if uploaded, err := p.uploadArchive(ctx, ui, comm, targetDir, sourceFiles, true); uploaded || err != nil {
+			return err
+		}
+	}
 	for _, f := range sourceFiles {
-		if err := p.uploadSingleFile(ui, comm, f, targetDir); err != nil {
+		if err := p.uploadSingleFile(ctx, ui, comm, f, targetDir); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *Provisioner) uploadSingleFile(ui packersdk.Ui, comm packersdk.Communicator, uploadFile string, uploadDir string) error {
-	localFile, _ := filepath.Abs(uploadFile)
+func (p *Provisioner) uploadSingleFile(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, uploadFile slsFile, uploadDir string) error {
+	localFile, _ := filepath.Abs(uploadFile.Source)
 	ui.Say(fmt.Sprintf("Uploading file %s to %s", localFile, uploadDir))
 
-	remoteDir := filepath.ToSlash(filepath.Join(uploadDir, filepath.Dir(uploadFile)))
-	remoteFile := filepath.ToSlash(filepath.Join(uploadDir, uploadFile))
+	remoteFile := path.Join(filepath.ToSlash(uploadDir), uploadFile.Path)
+	remoteDir := path.Dir(remoteFile)
 
-	if err := p.createDir(ui, comm, remoteDir); err != nil {
+	if err := p.createDir(ctx, ui, comm, remoteDir); err != nil {
 		return err
 	}
 
@@ -399,8 +1232,38 @@ func (p *Provisioner) uploadSingleFile(ui packersdk.Ui, comm packersdk.Communica
 	return nil
 }
 
-func (p *Provisioner) uploadDir(ui packersdk.Ui, comm packersdk.Communicator, dst, src string) error {
-	if err := p.createDir(ui, comm, dst); err != nil {
+func (p *Provisioner) uploadDir(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, dst, src string) error {
+	rules, err := readUploadRules(src, p.config.Exclude, p.config.Include)
+	if err != nil {
+		return err
+	}
+	// Excluded files are left out by uploading the remaining files as an archive, or by copying them to a
+	// local directory that is uploaded instead
+	if len(rules) > 0 || p.config.UploadStrategy == "archive" {
+		files, err := dirFiles(src, rules)
+		if err != nil {
+			if len(rules) > 0 {
+				return fmt.Errorf("error reading %s: %s", src, err)
+			}
+			ui.Say(fmt.Sprintf("Unable to archive %s, uploading it as a directory: %s", src, err))
+		} else {
+			if p.config.UploadStrategy == "archive" {
+				if uploaded, err := p.uploadArchive(ctx, ui, comm, dst, files, false); uploaded || err != nil {
+					return err
+				}
+			}
+			if len(rules) > 0 {
+				stageDir, err := stageFiles(files)
+				if err != nil {
+					return fmt.Errorf("error copying files from %s: %s", src, err)
+				}
+				defer os.RemoveAll(stageDir)
+				ui.Say(fmt.Sprintf("Excluding files from local directory %s, %d files remain", src, len(files)))
+				src = stageDir
+			}
+		}
+	}
+	if err := p.createDir(ctx, ui, comm, dst); err != nil {
 		return err
 	}
 	if src[len(src)-1] != '/' {
@@ -410,10 +1273,10 @@ func (p *Provisioner) uploadDir(ui packersdk.Ui, comm packersdk.Communicator, ds
 	return comm.UploadDir(dst, src, nil)
 }
 
-func (p *Provisioner) createDir(ui packersdk.Ui, comm packersdk.Communicator, dir string) error {
-	cmd := &packersdk.RemoteCmd{Command: fmt.Sprintf(p.getCommand("cmdCreateDir"), dir)}
+func (p *Provisioner) createDir(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, dir string) error {
+	cmd := &packersdk.RemoteCmd{Command: p.formatCommand("cmdCreateDir", dir)}
 	ui.Say(fmt.Sprintf("Creating directory: %s", dir))
-	if err := cmd.RunWithUi(context.TODO(), comm, ui); err != nil {
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
 		return err
 	}
 	if cmd.ExitStatus() != 0 {
@@ -422,13 +1285,34 @@ func (p *Provisioner) createDir(ui packersdk.Ui, comm packersdk.Communicator, di
 	return nil
 }
 
-func (p *Provisioner) removeDir(ui packersdk.Ui, comm packersdk.Communicator, dir string) error {
-	cmd := &packersdk.RemoteCmd{Command: fmt.Sprintf(p.getCommand("cmdDeleteDir"), dir)}
+func (p *Provisioner) removeDir(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, dir string) error {
+	cmd := &packersdk.RemoteCmd{Command: p.formatCommand("cmdDeleteDir", dir)}
 	ui.Say(fmt.Sprintf("Removing directory: %s", dir))
-	_ = cmd.RunWithUi(context.TODO(), comm, ui)
+	_ = cmd.RunWithUi(ctx, comm, ui)
 	return nil
 }
 
+func (p *Provisioner) removeFile(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, file string) error {
+	cmd := &packersdk.RemoteCmd{Command: p.formatCommand("cmdDeleteFile", file)}
+	ui.Say(fmt.Sprintf("Removing file: %s", file))
+	_ = cmd.RunWithUi(ctx, comm, ui)
+	return nil
+}
+
+func (p *Provisioner) runCommandWithOutput(ctx context.Context, comm packersdk.Communicator, command string) (string, int, error) {
+	cmd := &packersdk.RemoteCmd{Command: command}
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = io.Discard
+
+	if err := comm.Start(ctx, cmd); err != nil {
+		return "", 0, err
+	}
+	exitStatus := cmd.Wait()
+	return out.String(), exitStatus, nil
+}
+
 func (p *Provisioner) uploadFile(ui packersdk.Ui, comm packersdk.Communicator, dst, src string) error {
 	f, err := os.Open(src)
 	if err != nil {
@@ -462,61 +1346,332 @@ func validateFileConfig(path string, cfg string) error {
 // ----------------------------------------------------------------------------
 // Salt execution methods
 // ----------------------------------------------------------------------------
-func (p *Provisioner) executeSalt(ui packersdk.Ui, comm packersdk.Communicator) error {
+func (p *Provisioner) executeSalt(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator) error {
 	// Prepare environment variables
-	envVars := p.createFlattenedEnvVars()
+	envVars := ""
+	if p.inlineEnvVars {
+		envVars = p.createFlattenedEnvVars()
+	} else if keys, _ := p.getEnvVars(); len(keys) > 0 {
+		envFile, err := p.uploadEnvFile(ctx, ui, comm)
+		if err != nil {
+			return fmt.Errorf("error uploading environment variables: %s", err)
+		}
+		defer func() {
+			_ = p.removeDir(context.Background(), ui, comm, path.Dir(envFile))
+		}()
+		envVars = p.formatCommand("cmdEnvWrapper", envFile)
+	}
+
+	// Generate the minion configuration
+	p.configDir = ""
+	if p.usesMinionConfig() {
+		configDir, err := p.uploadMinionConfig(ctx, ui, comm)
+		if err != nil {
+			return fmt.Errorf("error uploading minion configuration: %s", err)
+		}
+		defer func() {
+			_ = p.removeDir(context.Background(), ui, comm, configDir)
+		}()
+		p.configDir = configDir
+	}
+
+	// Sync custom modules so that they are available to the first state run
+	if p.config.SyncModules || p.config.ExtensionModules != "" {
+		if err := p.syncModules(ctx, ui, comm, envVars); err != nil {
+			return fmt.Errorf("error syncing modules: %s", err)
+		}
+	}
+
+	// Select the steps to run, a step without a state name applies the highstate
+	steps := p.config.Steps
+	if len(steps) == 0 {
+		var stateNames []string
+		for _, f := range p.stateFiles {
+			stateNames = append(stateNames, f.Name)
+		}
+		for _, f := range p.treeStates {
+			stateNames = append(stateNames, f.Name)
+		}
+		highstate := len(stateNames) == 0 && (p.config.StateTree != "" || len(p.config.FileRoots) > 0)
+		for _, f := range p.inlineStates {
+			stateNames = append(stateNames, f.Name)
+		}
+		if p.config.ApplyMode == "combined" && len(stateNames) > 1 {
+			stateNames = []string{strings.Join(stateNames, ",")}
+		}
+		if highstate {
+			stateNames = append([]string{""}, stateNames...)
+		}
+		for _, name := range stateNames {
+			steps = append(steps, StateConfig{Name: name})
+		}
+	}
+
+	// Render the states before making any changes
+	if p.config.RenderCheck {
+		if err := p.renderCheck(ctx, ui, comm, envVars, steps); err != nil {
+			return fmt.Errorf("error rendering states: %s", err)
+		}
+	}
 
 	// Execute Salt
-	if len(p.config.StateFiles) != 0 {
-		for _, stateFile := range p.stateFiles {
-			if err := p.executeSaltState(ui, comm, envVars, stateFile); err != nil {
-				return err
+	var applied []StateConfig
+	for _, step := range steps {
+		if !step.runsOn(p.config.TargetOS) {
+			ui.Say(fmt.Sprintf("Skipping %s as it only runs on: %s", step.target(), strings.Join(step.OnlyOS, ", ")))
+			continue
+		}
+
+		test := step.testMode(p.config.TestMode)
+		run, err := p.executeSaltStep(ctx, ui, comm, envVars, step, test)
+		if err == nil && p.config.FailOnChanges {
+			err = run.changesErr()
+		}
+		if reportErr := p.recordStateRun(ui, run); reportErr != nil {
+			if err == nil {
+				return reportErr
 			}
+			ui.Error(reportErr.Error())
 		}
-	} else {
-		if err := p.executeSaltState(ui, comm, envVars, ""); err != nil {
+		if err != nil {
+			if step.IgnoreFailure && ctx.Err() == nil {
+				ui.Error(fmt.Sprintf("Ignoring failure of %s: %s", step.target(), err))
+				continue
+			}
 			return err
 		}
+		if !test {
+			applied = append(applied, step)
+		}
+	}
+
+	if p.config.VerifyIdempotency {
+		if len(applied) == 0 {
+			ui.Say("Skipping idempotency verification as no changes are applied in test mode")
+			return nil
+		}
+		return p.verifyIdempotency(ctx, ui, comm, envVars, applied)
 	}
 
 	return nil
 }
 
-func (p *Provisioner) executeSaltState(ui packersdk.Ui, comm packersdk.Communicator, envVars string, stateFile string) error {
-	ctx := context.TODO()
-	stateName := strings.ReplaceAll(stateFile, ".sls", "")
+// executeSaltStep runs a step, retrying it as many times as the step allows if it fails.
+func (p *Provisioner) executeSaltStep(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, envVars string, step StateConfig, test bool) (*stateRun, error) {
+	run, err := p.executeSaltState(ctx, ui, comm, envVars, step, test)
+	for attempt := 1; err != nil && attempt <= step.Retries && ctx.Err() == nil; attempt++ {
+		ui.Error(fmt.Sprintf("%s failed: %s", step.target(), err))
+		ui.Say(fmt.Sprintf("Retrying %s (retry %d of %d)...", step.target(), attempt, step.Retries))
+		run, err = p.executeSaltState(ctx, ui, comm, envVars, step, test)
+	}
+	return run, err
+}
 
-	var rawCommand string
-	if len(p.config.PillarTree) > 0 {
-		rawCommand = p.getCommand("cmdSaltCallPillar")
-	} else {
-		rawCommand = p.getCommand("cmdSaltCall")
+func (p *Provisioner) verifyIdempotency(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, envVars string, steps []StateConfig) error {
+	ui.Say("Verifying idempotency of applied states...")
+	test := p.config.IdempotencyMode == "test"
+
+	var offending []string
+	for _, step := range steps {
+		run, err := p.executeSaltState(ctx, ui, comm, envVars, step, test)
+		if run != nil {
+			run.Target += " (idempotency)"
+		}
+		if reportErr := p.recordStateRun(ui, run); reportErr != nil {
+			if err == nil {
+				return reportErr
+			}
+			ui.Error(reportErr.Error())
+		}
+		if err != nil {
+			return err
+		}
+		for _, s := range run.changed() {
+			offending = append(offending, s.ID)
+		}
 	}
 
-	// Select args based on whether PillarTree is present
-	var args []any
-	if len(p.config.PillarTree) > 0 {
-		args = []any{envVars, p.config.LogLevel, p.config.StateDir, p.config.PillarDir, stateName}
-	} else {
-		args = []any{envVars, p.config.LogLevel, p.config.StateDir, stateName}
+	if len(offending) != 0 {
+		return fmt.Errorf("%d states are not idempotent and reported changes on a second run: %s", len(offending), strings.Join(offending, ", "))
+	}
+	ui.Say("All states are idempotent")
+
+	return nil
+}
+
+func (p *Provisioner) executeSaltState(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, envVars string, step StateConfig, test bool) (*stateRun, error) {
+	target := step.target()
+	// A run that fails before salt-call returns any results is still reported
+	failed := func(err error) (*stateRun, error) {
+		return &stateRun{Target: target, Test: test, Error: err.Error()}, err
 	}
 
-	command := fmt.Sprintf(rawCommand, args...)
+	// salt-call always logs to a file so that the running state can be identified if it is killed
+	logFile := p.saltLogFile()
+
+	command, err := p.saltCallCommand(envVars, logFile, strings.TrimSpace("state.apply "+p.stateArgs(step, test)))
+	if err != nil {
+		return failed(err)
+	}
 
 	ui.Say(fmt.Sprintf("Executing Salt: %s", command))
+	out, exitStatus, err := p.runSaltCall(ctx, ui, comm, command, logFile, p.stepTimeout(step))
+	if err != nil {
+		return failed(err)
+	}
+
+	run, err := parseStateRun(target, out)
+	if err != nil {
+		if exitStatus != 0 {
+			err = fmt.Errorf("non-zero exit status: %d: %s", exitStatus, err)
+		}
+		if run == nil {
+			run = &stateRun{Target: target}
+		}
+		run.Test = test
+		run.Error = err.Error()
+		return run, err
+	}
+	run.Test = test
+	p.attributeStates(run)
+
+	p.printStateRun(ui, run)
+
+	if err := run.err(); err != nil {
+		return run, err
+	}
+	if exitStatus != 0 {
+		run.Error = fmt.Sprintf("non-zero exit status: %d", exitStatus)
+		return run, fmt.Errorf("%s", run.Error)
+	}
+
+	return run, nil
+}
+
+// stateArgs returns the arguments used to apply or render the states of a step.
+func (p *Provisioner) stateArgs(step StateConfig, test bool) string {
+	stateArgs := step.Name
+	if test {
+		stateArgs = strings.TrimSpace(stateArgs + " test=True")
+	}
+	saltenv := p.config.Saltenv
+	if step.Saltenv != "" {
+		saltenv = step.Saltenv
+	}
+	if saltenv != "" {
+		stateArgs = strings.TrimSpace(stateArgs + " saltenv=" + saltenv)
+	}
+	if p.config.Pillarenv != "" {
+		stateArgs = strings.TrimSpace(stateArgs + " pillarenv=" + p.config.Pillarenv)
+	}
+	if pillarArg := p.createPillarArg(step.Pillar); pillarArg != "" {
+		stateArgs = strings.TrimSpace(stateArgs + " " + pillarArg)
+	}
+	return stateArgs
+}
+
+// saltLogFile returns the path of the salt-call log file on the target system.
+func (p *Provisioner) saltLogFile() string {
+	return filepath.ToSlash(filepath.Join(p.config.StateDir, p.getConfig("configLogFile")))
+}
+
+// stepTimeout returns the maximum amount of time that each salt-call run for a
+// step may take, or zero if there is no limit.
+func (p *Provisioner) stepTimeout(step StateConfig) time.Duration {
+	if step.Timeout > 0 {
+		return step.Timeout
+	}
+	return p.config.StateTimeout
+}
+
+// runSaltCall runs a salt-call command, capturing its JSON output and streaming
+// its log messages. If the context is cancelled or the timeout is reached before
+// salt-call exits, Salt is stopped and an error identifying the state that was
+// running is returned.
+func (p *Provisioner) runSaltCall(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, command string, logFile string, timeout time.Duration) ([]byte, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	cmd := &packersdk.RemoteCmd{Command: command}
+	// Capture the JSON output, salt-call log messages are streamed as they arrive
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	stderr := &uiLineWriter{ui: ui}
+	cmd.Stderr = stderr
 
-	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
-		return err
+	if err := comm.Start(ctx, cmd); err != nil {
+		return nil, 0, err
 	}
-	if cmd.ExitStatus() != 0 {
-		if cmd.ExitStatus() == 127 {
-			return fmt.Errorf("%s could not be found, verify that it is available on the path after connecting to the machine", command)
+
+	// Not every communicator stops the command when the context is done, so
+	// salt-call is stopped explicitly
+	done := make(chan int, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var exitStatus int
+	select {
+	case exitStatus = <-done:
+	case <-ctx.Done():
+		err := p.killSalt(ui, comm, ctx.Err(), logFile)
+		select {
+		case <-done:
+			stderr.Flush()
+		case <-time.After(killTimeout):
 		}
-		return fmt.Errorf("non-zero exit status: %d", cmd.ExitStatus())
+		return nil, 0, err
+	}
+	stderr.Flush()
+	if exitStatus == 127 {
+		return nil, exitStatus, fmt.Errorf("%s could not be found, verify that it is available on the path after connecting to the machine", filterSecrets(command))
 	}
+	return out.Bytes(), exitStatus, nil
+}
 
-	return nil
+// killSalt stops a salt-call run that was cancelled or timed out, and returns an
+// error identifying the state that was running when it was stopped.
+func (p *Provisioner) killSalt(ui packersdk.Ui, comm packersdk.Communicator, reason error, logFile string) error {
+	ctx := context.Background()
+	action := "was cancelled"
+	if errors.Is(reason, context.DeadlineExceeded) {
+		action = "timed out"
+	}
+
+	ui.Error(fmt.Sprintf("salt-call %s, stopping Salt...", action))
+	cmd := &packersdk.RemoteCmd{Command: p.formatCommand("cmdKillSalt")}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		ui.Error(fmt.Sprintf("error stopping Salt: %s", err))
+	}
+
+	out, exitStatus, err := p.runCommandWithOutput(ctx, comm, p.formatCommand("cmdReadFile", logFile))
+	if err != nil || exitStatus != 0 {
+		return fmt.Errorf("salt-call %s, the running state could not be determined", action)
+	}
+	if state := runningState(out); state != "" {
+		return fmt.Errorf("salt-call %s while running state %s", action, state)
+	}
+	return fmt.Errorf("salt-call %s while no state was running", action)
+}
+
+// runningState returns the state that salt-call last started executing without
+// completing, according to its log file, for example "pkg.installed for [vim]".
+func runningState(log string) string {
+	var state string
+	for _, line := range strings.Split(log, "\n") {
+		if i := strings.Index(line, "Executing state "); i >= 0 {
+			state = strings.TrimSpace(line[i+len("Executing state "):])
+		} else if strings.Contains(line, "Completed state [") {
+			state = ""
+		}
+	}
+	return state
 }
 
 // ----------------------------------------------------------------------------
@@ -534,6 +1689,94 @@ func (p *Provisioner) getConfig(valueName string) string {
 	return saltConfigMap[valueName]
 }
 
+// createPillarArg returns the pillar argument for salt-call with the inline
+// pillar data, merged with the pillar data of a step, serialized as JSON, or
+// an empty string if there is none.
+func (p *Provisioner) createPillarArg(stepPillar map[string]string) string {
+	pillar := make(map[string]string)
+	for k, v := range p.config.Pillar {
+		pillar[k] = v
+	}
+	for k, v := range stepPillar {
+		pillar[k] = v
+	}
+	if len(pillar) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(decodePillarValues(pillar))
+
+	var escaped string
+	if p.config.TargetOS == "windows" {
+		escaped = escapeWindowsArg(escapeCmdJSON(string(data)))
+	} else {
+		escaped = strings.Replace(string(data), "'", `'"'"'`, -1)
+	}
+	return fmt.Sprintf(p.getConfig("configPillarArg"), escaped)
+}
+
+// decodePillarValues returns the pillar data with any values that are JSON
+// objects or arrays, such as those produced by jsonencode, decoded, and the
+// values true and false decoded as booleans. All other values are kept as
+// strings, so that values such as "3.10" are not changed.
+func decodePillarValues(pillar map[string]string) map[string]interface{} {
+	values := make(map[string]interface{}, len(pillar))
+	for k, v := range pillar {
+		values[k] = v
+		switch trimmed := strings.TrimSpace(v); {
+		case v == "true":
+			values[k] = true
+		case v == "false":
+			values[k] = false
+		case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["):
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(v), &decoded); err == nil {
+				values[k] = decoded
+			}
+		}
+	}
+	return values
+}
+
+// escapeWindowsArg escapes a value for use inside a double quoted Windows
+// command line argument. Double quotes are escaped with a backslash and any
+// backslashes that precede them are doubled.
+func escapeWindowsArg(value string) string {
+	var b strings.Builder
+	backslashes := 0
+	for _, r := range value {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		b.WriteRune(r)
+	}
+	// Backslashes before the closing quote of the argument must also be doubled
+	b.WriteString(strings.Repeat(`\`, backslashes*2))
+	return b.String()
+}
+
+// escapeCmdJSON replaces the characters in JSON data that cmd.exe would
+// interpret with JSON unicode escapes. cmd.exe does not recognise the escaped
+// quotes within a Windows argument, so the strings of the JSON data can end up
+// outside of its quotes, and variables are expanded even within them. The
+// characters &, < and > are already escaped by json.Marshal.
+func escapeCmdJSON(data string) string {
+	return cmdJSONReplacer.Replace(data)
+}
+
+var cmdJSONReplacer = strings.NewReplacer(
+	"%", `\u0025`,
+	"!", `\u0021`,
+	"^", `\u005e`,
+	"|", `\u007c`,
+)
+
 func (p *Provisioner) createFlattenedEnvVars() string {
 	keys, envVars := p.escapeEnvVars()
 
@@ -547,14 +1790,32 @@ func (p *Provisioner) createFlattenedEnvVars() string {
 }
 
 func (p *Provisioner) escapeEnvVars() ([]string, map[string]string) {
+	keys, envVars := p.getEnvVars()
+
+	// Replace any single quotes in values so they parse correctly with
+	// required environment variable format
+	for k, v := range envVars {
+		envVars[k] = strings.Replace(v, "'", `'"'"'`, -1)
+	}
+
+	return keys, envVars
+}
+
+// getEnvVars returns the environment variables from environment_vars and env
+// combined, with the keys in sorted order. Values in env take precedence.
+func (p *Provisioner) getEnvVars() ([]string, map[string]string) {
 	envVars := make(map[string]string)
 
 	// Split vars into key/value components
 	for _, envVar := range p.config.EnvVars {
 		keyValue := strings.SplitN(envVar, "=", 2)
-		// Store pair, replacing any single quotes in value so they parse
-		// correctly with required environment variable format
-		envVars[keyValue[0]] = strings.Replace(keyValue[1], "'", `'"'"'`, -1)
+		if len(keyValue) != 2 {
+			continue
+		}
+		envVars[keyValue[0]] = keyValue[1]
+	}
+	for k, v := range p.config.Env {
+		envVars[k] = v
 	}
 
 	// Create a list of env var keys in sorted order
@@ -570,9 +1831,7 @@ func (p *Provisioner) escapeEnvVars() ([]string, map[string]string) {
 // ----------------------------------------------------------------------------
 // Guest OS helper methods
 // ----------------------------------------------------------------------------
-func (p *Provisioner) detectGuestOS(comm packersdk.Communicator, ui packersdk.Ui) string {
-	ctx := context.TODO()
-
+func (p *Provisioner) detectGuestOS(ctx context.Context, comm packersdk.Communicator, ui packersdk.Ui) string {
 	ui.Say("Detecting guest OS type...")
 	command := "powershell -Command [System.Environment]::OSVersion.Platform"
 	cmd := &packersdk.RemoteCmd{Command: command}
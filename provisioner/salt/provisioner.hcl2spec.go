@@ -10,26 +10,72 @@ import (
 // FlatConfig is an auto-generated flat version of Config.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatConfig struct {
-	PackerBuildName     *string           `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
-	PackerBuilderType   *string           `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
-	PackerCoreVersion   *string           `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
-	PackerDebug         *bool             `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
-	PackerForce         *bool             `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
-	PackerOnError       *string           `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
-	PackerUserVars      map[string]string `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
-	PackerSensitiveVars []string          `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
-	TargetOS            *string           `mapstructure:"target_os" cty:"target_os" hcl:"target_os"`
-	StateFiles          []string          `mapstructure:"state_files" cty:"state_files" hcl:"state_files"`
-	StateTree           *string           `mapstructure:"state_tree" cty:"state_tree" hcl:"state_tree"`
-	StagingDir          *string           `mapstructure:"staging_directory" cty:"staging_directory" hcl:"staging_directory"`
-	StateDir            *string           `mapstructure:"state_directory" cty:"state_directory" hcl:"state_directory"`
-	PillarFiles         []string          `mapstructure:"pillar_files" cty:"pillar_files" hcl:"pillar_files"`
-	PillarTree          *string           `mapstructure:"pillar_tree" cty:"pillar_tree" hcl:"pillar_tree"`
-	PillarDir           *string           `mapstructure:"pillar_directory" cty:"pillar_directory" hcl:"pillar_directory"`
-	Clean               *bool             `mapstructure:"clean" cty:"clean" hcl:"clean"`
-	EnvVars             []string          `mapstructure:"environment_vars" cty:"environment_vars" hcl:"environment_vars"`
-	EnvVarFormat        *string           `mapstructure:"env_var_format" cty:"env_var_format" hcl:"env_var_format"`
-	LogLevel            *string           `mapstructure:"log_level" cty:"log_level" hcl:"log_level"`
+	PackerBuildName     *string             `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType   *string             `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerCoreVersion   *string             `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
+	PackerDebug         *bool               `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce         *bool               `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError       *string             `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars      map[string]string   `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars []string            `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	TargetOS            *string             `mapstructure:"target_os" cty:"target_os" hcl:"target_os"`
+	StateFiles          []string            `mapstructure:"state_files" cty:"state_files" hcl:"state_files"`
+	StateFilesRoot      *string             `mapstructure:"state_files_root" cty:"state_files_root" hcl:"state_files_root"`
+	ApplyMode           *string             `mapstructure:"apply_mode" cty:"apply_mode" hcl:"apply_mode"`
+	StateTree           *string             `mapstructure:"state_tree" cty:"state_tree" hcl:"state_tree"`
+	States              []string            `mapstructure:"states" cty:"states" hcl:"states"`
+	InlineStates        map[string]string   `mapstructure:"inline_states" cty:"inline_states" hcl:"inline_states"`
+	Steps               []FlatStateConfig   `mapstructure:"state" cty:"state" hcl:"state"`
+	StagingDir          *string             `mapstructure:"staging_directory" cty:"staging_directory" hcl:"staging_directory"`
+	StateDir            *string             `mapstructure:"state_directory" cty:"state_directory" hcl:"state_directory"`
+	PillarFiles         []string            `mapstructure:"pillar_files" cty:"pillar_files" hcl:"pillar_files"`
+	PillarFilesRoot     *string             `mapstructure:"pillar_files_root" cty:"pillar_files_root" hcl:"pillar_files_root"`
+	PillarTree          *string             `mapstructure:"pillar_tree" cty:"pillar_tree" hcl:"pillar_tree"`
+	PillarDir           *string             `mapstructure:"pillar_directory" cty:"pillar_directory" hcl:"pillar_directory"`
+	FileRoots           []FlatRootsConfig   `mapstructure:"file_roots" cty:"file_roots" hcl:"file_roots"`
+	PillarRoots         []FlatRootsConfig   `mapstructure:"pillar_roots" cty:"pillar_roots" hcl:"pillar_roots"`
+	Saltenv             *string             `mapstructure:"saltenv" cty:"saltenv" hcl:"saltenv"`
+	Pillarenv           *string             `mapstructure:"pillarenv" cty:"pillarenv" hcl:"pillarenv"`
+	MinionConfigFile    *string             `mapstructure:"minion_config_file" cty:"minion_config_file" hcl:"minion_config_file"`
+	MinionConfig        map[string]string   `mapstructure:"minion_config" cty:"minion_config" hcl:"minion_config"`
+	Formulas            []FlatFormulaConfig `mapstructure:"formulas" cty:"formulas" hcl:"formulas"`
+	FormulasLock        *string             `mapstructure:"formulas_lock" cty:"formulas_lock" hcl:"formulas_lock"`
+	ExtensionModules    *string             `mapstructure:"extension_modules" cty:"extension_modules" hcl:"extension_modules"`
+	SyncModules         *bool               `mapstructure:"sync_modules" cty:"sync_modules" hcl:"sync_modules"`
+	SyncTypes           []string            `mapstructure:"sync_types" cty:"sync_types" hcl:"sync_types"`
+	ValidateSLS         *bool               `mapstructure:"validate_sls" cty:"validate_sls" hcl:"validate_sls"`
+	RenderCheck         *bool               `mapstructure:"render_check" cty:"render_check" hcl:"render_check"`
+	RenderOutput        *string             `mapstructure:"render_output" cty:"render_output" hcl:"render_output"`
+	Exclude             []string            `mapstructure:"exclude" cty:"exclude" hcl:"exclude"`
+	Include             []string            `mapstructure:"include" cty:"include" hcl:"include"`
+	UploadStrategy      *string             `mapstructure:"upload_strategy" cty:"upload_strategy" hcl:"upload_strategy"`
+	Clean               *bool               `mapstructure:"clean" cty:"clean" hcl:"clean"`
+	EnvVars             []string            `mapstructure:"environment_vars" cty:"environment_vars" hcl:"environment_vars"`
+	Env                 map[string]string   `mapstructure:"env" cty:"env" hcl:"env"`
+	EnvVarFormat        *string             `mapstructure:"env_var_format" cty:"env_var_format" hcl:"env_var_format"`
+	LogLevel            *string             `mapstructure:"log_level" cty:"log_level" hcl:"log_level"`
+	ExecuteCommand      *string             `mapstructure:"execute_command" cty:"execute_command" hcl:"execute_command"`
+	SaltCallPath        *string             `mapstructure:"salt_call_path" cty:"salt_call_path" hcl:"salt_call_path"`
+	PreventSudo         *bool               `mapstructure:"prevent_sudo" cty:"prevent_sudo" hcl:"prevent_sudo"`
+	SudoPassword        *string             `mapstructure:"sudo_password" cty:"sudo_password" hcl:"sudo_password"`
+	InstallSalt         *bool               `mapstructure:"install_salt" cty:"install_salt" hcl:"install_salt"`
+	SaltVersion         *string             `mapstructure:"salt_version" cty:"salt_version" hcl:"salt_version"`
+	InstallMethod       *string             `mapstructure:"install_method" cty:"install_method" hcl:"install_method"`
+	BootstrapScript     *string             `mapstructure:"bootstrap_script" cty:"bootstrap_script" hcl:"bootstrap_script"`
+	BootstrapArgs       *string             `mapstructure:"bootstrap_args" cty:"bootstrap_args" hcl:"bootstrap_args"`
+	InstallPackages     []string            `mapstructure:"install_packages" cty:"install_packages" hcl:"install_packages"`
+	StateOutput         *string             `mapstructure:"state_output" cty:"state_output" hcl:"state_output"`
+	StateVerbose        *bool               `mapstructure:"state_verbose" cty:"state_verbose" hcl:"state_verbose"`
+	Reports             []FlatReportConfig  `mapstructure:"report" cty:"report" hcl:"report"`
+	TestMode            *bool               `mapstructure:"test_mode" cty:"test_mode" hcl:"test_mode"`
+	FailOnChanges       *bool               `mapstructure:"fail_on_changes" cty:"fail_on_changes" hcl:"fail_on_changes"`
+	VerifyIdempotency   *bool               `mapstructure:"verify_idempotency" cty:"verify_idempotency" hcl:"verify_idempotency"`
+	IdempotencyMode     *string             `mapstructure:"idempotency_mode" cty:"idempotency_mode" hcl:"idempotency_mode"`
+	ExecutionTimeout    *string             `mapstructure:"execution_timeout" cty:"execution_timeout" hcl:"execution_timeout"`
+	StateTimeout        *string             `mapstructure:"state_timeout" cty:"state_timeout" hcl:"state_timeout"`
+	Pillar              map[string]string   `mapstructure:"pillar" cty:"pillar" hcl:"pillar"`
+	SensitiveEnvVars    []string            `mapstructure:"sensitive_environment_vars" cty:"sensitive_environment_vars" hcl:"sensitive_environment_vars"`
+	SensitivePillarKeys []string            `mapstructure:"sensitive_pillar_keys" cty:"sensitive_pillar_keys" hcl:"sensitive_pillar_keys"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -54,16 +100,176 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"packer_sensitive_variables": &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
 		"target_os":                  &hcldec.AttrSpec{Name: "target_os", Type: cty.String, Required: false},
 		"state_files":                &hcldec.AttrSpec{Name: "state_files", Type: cty.List(cty.String), Required: false},
+		"state_files_root":           &hcldec.AttrSpec{Name: "state_files_root", Type: cty.String, Required: false},
+		"apply_mode":                 &hcldec.AttrSpec{Name: "apply_mode", Type: cty.String, Required: false},
 		"state_tree":                 &hcldec.AttrSpec{Name: "state_tree", Type: cty.String, Required: false},
+		"states":                     &hcldec.AttrSpec{Name: "states", Type: cty.List(cty.String), Required: false},
+		"inline_states":              &hcldec.AttrSpec{Name: "inline_states", Type: cty.Map(cty.String), Required: false},
+		"state":                      &hcldec.BlockListSpec{TypeName: "state", Nested: hcldec.ObjectSpec((*FlatStateConfig)(nil).HCL2Spec())},
 		"staging_directory":          &hcldec.AttrSpec{Name: "staging_directory", Type: cty.String, Required: false},
 		"state_directory":            &hcldec.AttrSpec{Name: "state_directory", Type: cty.String, Required: false},
 		"pillar_files":               &hcldec.AttrSpec{Name: "pillar_files", Type: cty.List(cty.String), Required: false},
+		"pillar_files_root":          &hcldec.AttrSpec{Name: "pillar_files_root", Type: cty.String, Required: false},
 		"pillar_tree":                &hcldec.AttrSpec{Name: "pillar_tree", Type: cty.String, Required: false},
 		"pillar_directory":           &hcldec.AttrSpec{Name: "pillar_directory", Type: cty.String, Required: false},
+		"file_roots":                 &hcldec.BlockListSpec{TypeName: "file_roots", Nested: hcldec.ObjectSpec((*FlatRootsConfig)(nil).HCL2Spec())},
+		"pillar_roots":               &hcldec.BlockListSpec{TypeName: "pillar_roots", Nested: hcldec.ObjectSpec((*FlatRootsConfig)(nil).HCL2Spec())},
+		"saltenv":                    &hcldec.AttrSpec{Name: "saltenv", Type: cty.String, Required: false},
+		"pillarenv":                  &hcldec.AttrSpec{Name: "pillarenv", Type: cty.String, Required: false},
+		"minion_config_file":         &hcldec.AttrSpec{Name: "minion_config_file", Type: cty.String, Required: false},
+		"minion_config":              &hcldec.AttrSpec{Name: "minion_config", Type: cty.Map(cty.String), Required: false},
+		"formulas":                   &hcldec.BlockListSpec{TypeName: "formulas", Nested: hcldec.ObjectSpec((*FlatFormulaConfig)(nil).HCL2Spec())},
+		"formulas_lock":              &hcldec.AttrSpec{Name: "formulas_lock", Type: cty.String, Required: false},
+		"extension_modules":          &hcldec.AttrSpec{Name: "extension_modules", Type: cty.String, Required: false},
+		"sync_modules":               &hcldec.AttrSpec{Name: "sync_modules", Type: cty.Bool, Required: false},
+		"sync_types":                 &hcldec.AttrSpec{Name: "sync_types", Type: cty.List(cty.String), Required: false},
+		"validate_sls":               &hcldec.AttrSpec{Name: "validate_sls", Type: cty.Bool, Required: false},
+		"render_check":               &hcldec.AttrSpec{Name: "render_check", Type: cty.Bool, Required: false},
+		"render_output":              &hcldec.AttrSpec{Name: "render_output", Type: cty.String, Required: false},
+		"exclude":                    &hcldec.AttrSpec{Name: "exclude", Type: cty.List(cty.String), Required: false},
+		"include":                    &hcldec.AttrSpec{Name: "include", Type: cty.List(cty.String), Required: false},
+		"upload_strategy":            &hcldec.AttrSpec{Name: "upload_strategy", Type: cty.String, Required: false},
 		"clean":                      &hcldec.AttrSpec{Name: "clean", Type: cty.Bool, Required: false},
 		"environment_vars":           &hcldec.AttrSpec{Name: "environment_vars", Type: cty.List(cty.String), Required: false},
+		"env":                        &hcldec.AttrSpec{Name: "env", Type: cty.Map(cty.String), Required: false},
 		"env_var_format":             &hcldec.AttrSpec{Name: "env_var_format", Type: cty.String, Required: false},
 		"log_level":                  &hcldec.AttrSpec{Name: "log_level", Type: cty.String, Required: false},
+		"execute_command":            &hcldec.AttrSpec{Name: "execute_command", Type: cty.String, Required: false},
+		"salt_call_path":             &hcldec.AttrSpec{Name: "salt_call_path", Type: cty.String, Required: false},
+		"prevent_sudo":               &hcldec.AttrSpec{Name: "prevent_sudo", Type: cty.Bool, Required: false},
+		"sudo_password":              &hcldec.AttrSpec{Name: "sudo_password", Type: cty.String, Required: false},
+		"install_salt":               &hcldec.AttrSpec{Name: "install_salt", Type: cty.Bool, Required: false},
+		"salt_version":               &hcldec.AttrSpec{Name: "salt_version", Type: cty.String, Required: false},
+		"install_method":             &hcldec.AttrSpec{Name: "install_method", Type: cty.String, Required: false},
+		"bootstrap_script":           &hcldec.AttrSpec{Name: "bootstrap_script", Type: cty.String, Required: false},
+		"bootstrap_args":             &hcldec.AttrSpec{Name: "bootstrap_args", Type: cty.String, Required: false},
+		"install_packages":           &hcldec.AttrSpec{Name: "install_packages", Type: cty.List(cty.String), Required: false},
+		"state_output":               &hcldec.AttrSpec{Name: "state_output", Type: cty.String, Required: false},
+		"state_verbose":              &hcldec.AttrSpec{Name: "state_verbose", Type: cty.Bool, Required: false},
+		"report":                     &hcldec.BlockListSpec{TypeName: "report", Nested: hcldec.ObjectSpec((*FlatReportConfig)(nil).HCL2Spec())},
+		"test_mode":                  &hcldec.AttrSpec{Name: "test_mode", Type: cty.Bool, Required: false},
+		"fail_on_changes":            &hcldec.AttrSpec{Name: "fail_on_changes", Type: cty.Bool, Required: false},
+		"verify_idempotency":         &hcldec.AttrSpec{Name: "verify_idempotency", Type: cty.Bool, Required: false},
+		"idempotency_mode":           &hcldec.AttrSpec{Name: "idempotency_mode", Type: cty.String, Required: false},
+		"execution_timeout":          &hcldec.AttrSpec{Name: "execution_timeout", Type: cty.String, Required: false},
+		"state_timeout":              &hcldec.AttrSpec{Name: "state_timeout", Type: cty.String, Required: false},
+		"pillar":                     &hcldec.AttrSpec{Name: "pillar", Type: cty.Map(cty.String), Required: false},
+		"sensitive_environment_vars": &hcldec.AttrSpec{Name: "sensitive_environment_vars", Type: cty.List(cty.String), Required: false},
+		"sensitive_pillar_keys":      &hcldec.AttrSpec{Name: "sensitive_pillar_keys", Type: cty.List(cty.String), Required: false},
+	}
+	return s
+}
+
+// FlatFormulaConfig is an auto-generated flat version of FormulaConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatFormulaConfig struct {
+	URL    *string `mapstructure:"url" required:"true" cty:"url" hcl:"url"`
+	Ref    *string `mapstructure:"ref" cty:"ref" hcl:"ref"`
+	Subdir *string `mapstructure:"subdir" cty:"subdir" hcl:"subdir"`
+}
+
+// FlatMapstructure returns a new FlatFormulaConfig.
+// FlatFormulaConfig is an auto-generated flat version of FormulaConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*FormulaConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatFormulaConfig)
+}
+
+// HCL2Spec returns the hcl spec of a FormulaConfig.
+// This spec is used by HCL to read the fields of FormulaConfig.
+// The decoded values from this spec will then be applied to a FlatFormulaConfig.
+func (*FlatFormulaConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"url":    &hcldec.AttrSpec{Name: "url", Type: cty.String, Required: false},
+		"ref":    &hcldec.AttrSpec{Name: "ref", Type: cty.String, Required: false},
+		"subdir": &hcldec.AttrSpec{Name: "subdir", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatReportConfig is an auto-generated flat version of ReportConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatReportConfig struct {
+	Path   *string `mapstructure:"path" required:"true" cty:"path" hcl:"path"`
+	Format *string `mapstructure:"format" cty:"format" hcl:"format"`
+}
+
+// FlatMapstructure returns a new FlatReportConfig.
+// FlatReportConfig is an auto-generated flat version of ReportConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*ReportConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatReportConfig)
+}
+
+// HCL2Spec returns the hcl spec of a ReportConfig.
+// This spec is used by HCL to read the fields of ReportConfig.
+// The decoded values from this spec will then be applied to a FlatReportConfig.
+func (*FlatReportConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"path":   &hcldec.AttrSpec{Name: "path", Type: cty.String, Required: false},
+		"format": &hcldec.AttrSpec{Name: "format", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatRootsConfig is an auto-generated flat version of RootsConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatRootsConfig struct {
+	Saltenv *string  `mapstructure:"saltenv" required:"true" cty:"saltenv" hcl:"saltenv"`
+	Paths   []string `mapstructure:"paths" required:"true" cty:"paths" hcl:"paths"`
+}
+
+// FlatMapstructure returns a new FlatRootsConfig.
+// FlatRootsConfig is an auto-generated flat version of RootsConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*RootsConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatRootsConfig)
+}
+
+// HCL2Spec returns the hcl spec of a RootsConfig.
+// This spec is used by HCL to read the fields of RootsConfig.
+// The decoded values from this spec will then be applied to a FlatRootsConfig.
+func (*FlatRootsConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"saltenv": &hcldec.AttrSpec{Name: "saltenv", Type: cty.String, Required: false},
+		"paths":   &hcldec.AttrSpec{Name: "paths", Type: cty.List(cty.String), Required: false},
+	}
+	return s
+}
+
+// FlatStateConfig is an auto-generated flat version of StateConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatStateConfig struct {
+	Name          *string           `mapstructure:"name" cty:"name" hcl:"name"`
+	Pillar        map[string]string `mapstructure:"pillar" cty:"pillar" hcl:"pillar"`
+	Saltenv       *string           `mapstructure:"saltenv" cty:"saltenv" hcl:"saltenv"`
+	Test          *bool             `mapstructure:"test" cty:"test" hcl:"test"`
+	Retries       *int              `mapstructure:"retries" cty:"retries" hcl:"retries"`
+	Timeout       *string           `mapstructure:"timeout" cty:"timeout" hcl:"timeout"`
+	OnlyOS        []string          `mapstructure:"only_os" cty:"only_os" hcl:"only_os"`
+	IgnoreFailure *bool             `mapstructure:"ignore_failure" cty:"ignore_failure" hcl:"ignore_failure"`
+}
+
+// FlatMapstructure returns a new FlatStateConfig.
+// FlatStateConfig is an auto-generated flat version of StateConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*StateConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatStateConfig)
+}
+
+// HCL2Spec returns the hcl spec of a StateConfig.
+// This spec is used by HCL to read the fields of StateConfig.
+// The decoded values from this spec will then be applied to a FlatStateConfig.
+func (*FlatStateConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"name":           &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"pillar":         &hcldec.AttrSpec{Name: "pillar", Type: cty.Map(cty.String), Required: false},
+		"saltenv":        &hcldec.AttrSpec{Name: "saltenv", Type: cty.String, Required: false},
+		"test":           &hcldec.AttrSpec{Name: "test", Type: cty.Bool, Required: false},
+		"retries":        &hcldec.AttrSpec{Name: "retries", Type: cty.Number, Required: false},
+		"timeout":        &hcldec.AttrSpec{Name: "timeout", Type: cty.String, Required: false},
+		"only_os":        &hcldec.AttrSpec{Name: "only_os", Type: cty.List(cty.String), Required: false},
+		"ignore_failure": &hcldec.AttrSpec{Name: "ignore_failure", Type: cty.Bool, Required: false},
 	}
 	return s
 }
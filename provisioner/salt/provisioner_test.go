@@ -0,0 +1,213 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// hangingCommunicator never completes salt-call until Salt is stopped, like an
+// SSH communicator that ignores the context passed to Start.
+type hangingCommunicator struct {
+	packersdk.MockCommunicator
+
+	mu       sync.Mutex
+	commands []string
+	running  *packersdk.RemoteCmd
+}
+
+func (c *hangingCommunicator) Start(ctx context.Context, cmd *packersdk.RemoteCmd) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands = append(c.commands, cmd.Command)
+
+	switch {
+	case strings.Contains(cmd.Command, "pkill"):
+		if c.running != nil {
+			c.running.SetExited(143)
+			c.running = nil
+		}
+		go cmd.SetExited(0)
+	case strings.HasPrefix(cmd.Command, "sudo cat"):
+		go func() {
+			fmt.Fprintln(cmd.Stdout, "[INFO    ] Executing state saltutil.sync_all")
+			cmd.SetExited(0)
+		}()
+	case strings.Contains(cmd.Command, "salt-call"):
+		c.running = cmd
+	default:
+		go cmd.SetExited(0)
+	}
+	return nil
+}
+
+func (c *hangingCommunicator) stopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, command := range c.commands {
+		if strings.Contains(command, "pkill") {
+			return true
+		}
+	}
+	return false
+}
+
+func testSaltCallProvisioner() *Provisioner {
+	p := &Provisioner{}
+	p.config.TargetOS = "linux"
+	p.config.StateDir = "/tmp/salt"
+	p.config.SaltCallPath = "salt-call"
+	p.config.LogLevel = "info"
+	return p
+}
+
+func TestSyncModulesTimeout(t *testing.T) {
+	p := testSaltCallProvisioner()
+	p.config.StateTimeout = 50 * time.Millisecond
+	comm := &hangingCommunicator{}
+
+	err := p.syncModules(context.Background(), packersdk.TestUi(t), comm, "")
+	if err == nil {
+		t.Fatal("syncModules succeeded; want a timeout error")
+	}
+	if !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if !comm.stopped() {
+		t.Error("salt-call was not stopped")
+	}
+}
+
+func TestRenderCheckCancelled(t *testing.T) {
+	p := testSaltCallProvisioner()
+	comm := &hangingCommunicator{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := p.renderCheck(ctx, packersdk.TestUi(t), comm, "", []StateConfig{{}})
+	if err == nil {
+		t.Fatal("renderCheck succeeded; want a cancellation error")
+	}
+	if !strings.Contains(err.Error(), "was cancelled") {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if !comm.stopped() {
+		t.Error("salt-call was not stopped")
+	}
+}
+
+// parseWindowsArg decodes a double quoted Windows command line argument using
+// the rules of the Microsoft C runtime.
+func parseWindowsArg(arg string) string {
+	var b strings.Builder
+	backslashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, backslashes/2))
+			if backslashes%2 == 1 {
+				b.WriteRune(r)
+			}
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+			b.WriteRune(r)
+		}
+		backslashes = 0
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes))
+	return b.String()
+}
+
+func TestEscapeWindowsArg(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`plain`, `plain`},
+		{`say "hi"`, `say \"hi\"`},
+		{`C:\path\`, `C:\path\\`},
+		{`a\"b`, `a\\\"b`},
+		{`a\\b`, `a\\b`},
+	}
+	for _, tt := range tests {
+		got := escapeWindowsArg(tt.value)
+		if got != tt.want {
+			t.Errorf("escapeWindowsArg(%q) = %q; want %q", tt.value, got, tt.want)
+		}
+		// The quotes of the argument are removed by parseWindowsArg
+		if parsed := parseWindowsArg(`"` + got + `"`); parsed != tt.value {
+			t.Errorf("escapeWindowsArg(%q) is parsed as %q", tt.value, parsed)
+		}
+	}
+}
+
+func TestDecodePillarValues(t *testing.T) {
+	got := decodePillarValues(map[string]string{
+		"version": "3.10",
+		"count":   "42",
+		"enabled": "true",
+		"debug":   "false",
+		"title":   "True",
+		"users":   `["alice", "bob"]`,
+		"nginx":   ` {"worker_processes": 4, "gzip": true}`,
+		"invalid": "{not json",
+	})
+	want := map[string]interface{}{
+		"version": "3.10",
+		"count":   "42",
+		"enabled": true,
+		"debug":   false,
+		"title":   "True",
+		"users":   []interface{}{"alice", "bob"},
+		"nginx":   map[string]interface{}{"worker_processes": float64(4), "gzip": true},
+		"invalid": "{not json",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodePillarValues = %#v; want %#v", got, want)
+	}
+}
+
+func TestCreatePillarArgWindows(t *testing.T) {
+	p := &Provisioner{}
+	p.config.TargetOS = "windows"
+	p.config.Pillar = map[string]string{
+		"message": `a|b ^c %PATH% !x! & <y> "quoted" C:\dir\`,
+		"nested":  `{"key": "50%|more"}`,
+	}
+
+	arg := p.createPillarArg(map[string]string{"step": "(x)"})
+	if strings.ContainsAny(arg, "%!^|&<>") {
+		t.Fatalf("pillar argument contains cmd.exe metacharacters: %s", arg)
+	}
+
+	value, ok := strings.CutPrefix(arg, "pillar=")
+	if !ok {
+		t.Fatalf("unexpected pillar argument: %s", arg)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(parseWindowsArg(value)), &got); err != nil {
+		t.Fatalf("pillar argument %s is not valid JSON: %s", arg, err)
+	}
+	want := map[string]interface{}{
+		"message": `a|b ^c %PATH% !x! & <y> "quoted" C:\dir\`,
+		"nested":  map[string]interface{}{"key": "50%|more"},
+		"step":    "(x)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pillar argument decodes to %#v; want %#v", got, want)
+	}
+}
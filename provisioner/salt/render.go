@@ -0,0 +1,158 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// renderedTarget is the state data rendered for the target of a step.
+type renderedTarget struct {
+	Target string          `json:"target"`
+	States json.RawMessage `json:"states"`
+}
+
+// ----------------------------------------------------------------------------
+// Render check methods
+// ----------------------------------------------------------------------------
+
+// renderCheck renders the states of each step without applying them, using
+// state.show_sls or state.show_highstate, and fails if any of them cannot be
+// rendered. The rendered state data is written to render_output if set.
+func (p *Provisioner) renderCheck(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator, envVars string, steps []StateConfig) error {
+	var rendered []renderedTarget
+	var failed []string
+	for _, step := range steps {
+		if !step.runsOn(p.config.TargetOS) {
+			continue
+		}
+
+		function := "state.show_sls"
+		if step.Name == "" {
+			function = "state.show_highstate"
+		}
+		logFile := p.saltLogFile()
+		command, err := p.saltCallCommand(envVars, logFile, strings.TrimSpace(function+" "+p.stateArgs(step, false)))
+		if err != nil {
+			return err
+		}
+
+		ui.Say(fmt.Sprintf("Rendering %s: %s", step.target(), command))
+		out, exitStatus, err := p.runSaltCall(ctx, ui, comm, command, logFile, p.stepTimeout(step))
+		if err != nil {
+			return err
+		}
+
+		states, renderErrs, err := parseRenderResult(out)
+		if err != nil {
+			if exitStatus != 0 {
+				err = fmt.Errorf("non-zero exit status: %d: %s", exitStatus, err)
+			}
+			failed = append(failed, fmt.Sprintf("%s: %s", step.target(), err))
+			continue
+		}
+		if len(renderErrs) > 0 {
+			for _, e := range renderErrs {
+				ui.Error(e)
+				failed = append(failed, fmt.Sprintf("%s: %s", step.target(), strings.SplitN(e, "\n", 2)[0]))
+			}
+			continue
+		}
+		if exitStatus != 0 {
+			failed = append(failed, fmt.Sprintf("%s: non-zero exit status: %d", step.target(), exitStatus))
+			continue
+		}
+
+		var ids map[string]json.RawMessage
+		_ = json.Unmarshal(states, &ids)
+		ui.Say(fmt.Sprintf("Rendered %d states for %s", len(ids), step.target()))
+		rendered = append(rendered, renderedTarget{Target: step.target(), States: filterRenderedStates(states)})
+	}
+
+	if p.config.RenderOutput != "" {
+		ui.Say(fmt.Sprintf("Writing rendered states to %s", p.config.RenderOutput))
+		if err := writeRenderOutput(p.config.RenderOutput, rendered); err != nil {
+			return fmt.Errorf("error writing %s: %s", p.config.RenderOutput, err)
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("%d states could not be rendered: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// parseRenderResult returns the rendered state data from the JSON output of
+// state.show_sls or state.show_highstate, or the render errors reported by Salt.
+func parseRenderResult(output []byte) (json.RawMessage, []string, error) {
+	var result struct {
+		Local json.RawMessage `json:"local"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(output), &result); err != nil || result.Local == nil {
+		return nil, nil, fmt.Errorf("unable to parse rendered states")
+	}
+
+	if trimmed := bytes.TrimSpace(result.Local); len(trimmed) > 0 && trimmed[0] == '{' {
+		return result.Local, nil, nil
+	}
+
+	// Salt reports render errors as a list of messages, or a single message
+	var messages []string
+	if err := json.Unmarshal(result.Local, &messages); err == nil {
+		return nil, messages, nil
+	}
+	var message string
+	if err := json.Unmarshal(result.Local, &message); err == nil {
+		return nil, []string{message}, nil
+	}
+	return nil, nil, fmt.Errorf("unable to parse rendered states")
+}
+
+// filterRenderedStates returns the rendered state data with any registered
+// secrets masked. The data is decoded first, as the JSON encoded form of a
+// secret may no longer match.
+func filterRenderedStates(states json.RawMessage) json.RawMessage {
+	dec := json.NewDecoder(bytes.NewReader(states))
+	dec.UseNumber()
+	var data interface{}
+	if err := dec.Decode(&data); err != nil {
+		return json.RawMessage(filterSecrets(string(states)))
+	}
+	var filtered bytes.Buffer
+	enc := json.NewEncoder(&filtered)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(filterValue(data)); err != nil {
+		return json.RawMessage(filterSecrets(string(states)))
+	}
+	return bytes.TrimSpace(filtered.Bytes())
+}
+
+func writeRenderOutput(file string, rendered []renderedTarget) error {
+	if rendered == nil {
+		rendered = []renderedTarget{}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(struct {
+		Targets []renderedTarget `json:"targets"`
+	}{rendered}); err != nil {
+		return err
+	}
+	if dir := filepath.Dir(file); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(file, []byte(filterSecrets(buf.String())), 0644)
+}
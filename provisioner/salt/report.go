@@ -0,0 +1,250 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// reportWriters maps each supported report format to the function that renders it.
+var reportWriters = map[string]func(io.Writer, []*stateRun) error{
+	"json":     writeJSONReport,
+	"junit":    writeJUnitReport,
+	"markdown": writeMarkdownReport,
+	"html":     writeHTMLReport,
+}
+
+// ----------------------------------------------------------------------------
+// Report methods
+// ----------------------------------------------------------------------------
+
+// recordStateRun adds the results of a salt-call run to those already
+// collected and rewrites any configured reports.
+func (p *Provisioner) recordStateRun(ui packersdk.Ui, run *stateRun) error {
+	if run == nil {
+		return nil
+	}
+	p.stateRuns = append(p.stateRuns, run)
+
+	for _, r := range p.config.Reports {
+		ui.Say(fmt.Sprintf("Writing %s report to %s", r.Format, r.Path))
+		if err := writeReport(r, p.stateRuns); err != nil {
+			return fmt.Errorf("error writing report %s: %s", r.Path, err)
+		}
+	}
+	return nil
+}
+
+func writeReport(r ReportConfig, runs []*stateRun) error {
+	var buf bytes.Buffer
+	if err := reportWriters[r.Format](&buf, filterStateRuns(runs)); err != nil {
+		return err
+	}
+	if dir := filepath.Dir(r.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(r.Path, []byte(filterSecrets(buf.String())), 0644)
+}
+
+func writeJSONReport(w io.Writer, runs []*stateRun) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(struct {
+		Runs []*stateRun `json:"runs"`
+	}{runs})
+}
+
+// JUnit XML report structure
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func writeJUnitReport(w io.Writer, runs []*stateRun) error {
+	report := junitTestSuites{Name: "salt"}
+	var total float64
+
+	for _, run := range runs {
+		suite := junitTestSuite{Name: run.Target}
+		var suiteTime float64
+
+		for _, s := range run.States {
+			tc := junitTestCase{
+				Name:      s.ID,
+				ClassName: s.Function,
+				File:      s.File,
+				Time:      formatSeconds(s.Duration),
+			}
+			if s.SLS != "" {
+				tc.ClassName = s.SLS + "." + s.Function
+			}
+			switch {
+			case s.failed():
+				tc.Failure = &junitMessage{Message: firstLine(s.Comment), Text: s.Comment}
+				suite.Failures++
+			case s.Result == nil:
+				tc.Skipped = &junitMessage{Message: firstLine(s.Comment), Text: s.Comment}
+				suite.Skipped++
+			}
+			if len(s.Changes) != 0 {
+				changes, _ := json.MarshalIndent(s.Changes, "", "  ")
+				tc.SystemOut = string(changes)
+			}
+			suite.TestCases = append(suite.TestCases, tc)
+			suiteTime += s.Duration
+		}
+
+		if run.Error != "" {
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				Name:      "salt-call",
+				ClassName: run.Target,
+				Time:      formatSeconds(0),
+				Error:     &junitMessage{Message: firstLine(run.Error), Text: run.Error},
+			})
+			suite.Errors++
+		}
+
+		suite.Tests = len(suite.TestCases)
+		suite.Time = formatSeconds(suiteTime)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Suites = append(report.Suites, suite)
+		total += suiteTime
+	}
+	report.Time = formatSeconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeMarkdownReport(w io.Writer, runs []*stateRun) error {
+	var b strings.Builder
+
+	b.WriteString("# Salt State Report\n")
+	for _, run := range runs {
+		fmt.Fprintf(&b, "\n## %s\n\n", run.Target)
+		if run.Error != "" {
+			fmt.Fprintf(&b, "**Error:** %s\n\n", escapeMarkdown(run.Error))
+		}
+		if len(run.States) == 0 {
+			b.WriteString("No states were run.\n")
+			continue
+		}
+		b.WriteString("| State | Function | Name | Result | Duration (ms) | Comment |\n")
+		b.WriteString("|-------|----------|------|--------|---------------|---------|\n")
+		for _, s := range run.States {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %.3f | %s |\n",
+				escapeMarkdown(s.ID), escapeMarkdown(s.Function), escapeMarkdown(s.Name),
+				s.resultString(), s.Duration, escapeMarkdown(s.Comment))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"result": func(s stateResult) string { return s.resultString() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Salt State Report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+td.comment { white-space: pre-wrap; }
+tr.Failed { background-color: #fdd; }
+tr.Changed { background-color: #ffd; }
+</style>
+</head>
+<body>
+<h1>Salt State Report</h1>
+{{- range . }}
+<h2>{{ .Target }}</h2>
+{{- if .Error }}
+<p><strong>Error:</strong> {{ .Error }}</p>
+{{- end }}
+<table>
+<tr><th>State</th><th>Function</th><th>Name</th><th>Result</th><th>Duration (ms)</th><th>Comment</th></tr>
+{{- range .States }}
+<tr class="{{ result . }}"><td>{{ .ID }}</td><td>{{ .Function }}</td><td>{{ .Name }}</td><td>{{ result . }}</td><td>{{ printf "%.3f" .Duration }}</td><td class="comment">{{ .Comment }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`))
+
+func writeHTMLReport(w io.Writer, runs []*stateRun) error {
+	return htmlReportTemplate.Execute(w, runs)
+}
+
+func formatSeconds(ms float64) string {
+	return fmt.Sprintf("%.3f", ms/1000)
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
+
+func escapeMarkdown(text string) string {
+	text = strings.ReplaceAll(text, "|", "\\|")
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
@@ -0,0 +1,209 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// stateResult is the outcome of a single state returned by salt-call.
+type stateResult struct {
+	ID        string                 `json:"id"`
+	Name      string                 `json:"name"`
+	Function  string                 `json:"function"`
+	SLS       string                 `json:"sls"`
+	File      string                 `json:"file,omitempty"`
+	RunNum    int                    `json:"run_num"`
+	Result    *bool                  `json:"result"`
+	Comment   string                 `json:"comment"`
+	Changes   map[string]interface{} `json:"changes"`
+	StartTime string                 `json:"start_time"`
+	Duration  float64                `json:"duration"`
+}
+
+// stateRun holds the results of a single salt-call invocation.
+type stateRun struct {
+	Target string        `json:"target"`
+	Test   bool          `json:"test"`
+	States []stateResult `json:"states"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// rawStateResult mirrors the structure of a state return in the JSON
+// output of salt-call.
+type rawStateResult struct {
+	ID        string                 `json:"__id__"`
+	Name      interface{}            `json:"name"`
+	SLS       string                 `json:"__sls__"`
+	RunNum    int                    `json:"__run_num__"`
+	Result    *bool                  `json:"result"`
+	Comment   interface{}            `json:"comment"`
+	Changes   map[string]interface{} `json:"changes"`
+	StartTime string                 `json:"start_time"`
+	Duration  interface{}            `json:"duration"`
+}
+
+// parseStateRun decodes the JSON output of a salt-call state run. The output is
+// expected to be a single object keyed by minion ID (always "local" for a
+// masterless run), containing either the state returns, or a list of errors or
+// a single error message when the states could not be compiled or run.
+func parseStateRun(target string, output []byte) (*stateRun, error) {
+	start := bytes.IndexByte(output, '{')
+	if start < 0 {
+		return nil, fmt.Errorf("no JSON output found")
+	}
+
+	var minions map[string]json.RawMessage
+	if err := json.NewDecoder(bytes.NewReader(output[start:])).Decode(&minions); err != nil {
+		return nil, fmt.Errorf("error decoding salt-call output: %s", err)
+	}
+
+	run := &stateRun{Target: target}
+	for _, raw := range minions {
+		var errs []string
+		if err := json.Unmarshal(raw, &errs); err == nil {
+			return run, fmt.Errorf("%s", strings.Join(errs, "; "))
+		}
+		// Salt reports some errors, such as an unavailable function, as a string
+		var message string
+		if err := json.Unmarshal(raw, &message); err == nil {
+			return run, fmt.Errorf("%s", message)
+		}
+
+		var states map[string]rawStateResult
+		if err := json.Unmarshal(raw, &states); err != nil {
+			return run, fmt.Errorf("error decoding state results: %s", err)
+		}
+		for key, s := range states {
+			run.States = append(run.States, newStateResult(key, s))
+		}
+	}
+
+	sort.SliceStable(run.States, func(i, j int) bool {
+		return run.States[i].RunNum < run.States[j].RunNum
+	})
+
+	return run, nil
+}
+
+func newStateResult(key string, raw rawStateResult) stateResult {
+	// State keys take the form "<module>_|-<id>_|-<name>_|-<function>"
+	parts := strings.Split(key, "_|-")
+
+	result := stateResult{
+		ID:        raw.ID,
+		Name:      fmt.Sprint(raw.Name),
+		SLS:       raw.SLS,
+		RunNum:    raw.RunNum,
+		Result:    raw.Result,
+		Comment:   flattenComment(raw.Comment),
+		Changes:   raw.Changes,
+		StartTime: raw.StartTime,
+	}
+	if len(parts) == 4 {
+		result.Function = parts[0] + "." + parts[3]
+		if result.ID == "" {
+			result.ID = parts[1]
+		}
+		if raw.Name == nil {
+			result.Name = parts[2]
+		}
+	}
+
+	switch d := raw.Duration.(type) {
+	case float64:
+		result.Duration = d
+	case string:
+		// Older Salt releases report the duration as a string such as "12.3 ms"
+		_, _ = fmt.Sscanf(d, "%g", &result.Duration)
+	}
+
+	return result
+}
+
+func flattenComment(comment interface{}) string {
+	switch c := comment.(type) {
+	case nil:
+		return ""
+	case string:
+		return c
+	case []interface{}:
+		lines := make([]string, len(c))
+		for i, line := range c {
+			lines[i] = fmt.Sprint(line)
+		}
+		return strings.Join(lines, "\n")
+	default:
+		return fmt.Sprint(c)
+	}
+}
+
+// failed reports whether the state returned a result of false.
+func (s stateResult) failed() bool {
+	return s.Result != nil && !*s.Result
+}
+
+// changed reports whether the state made changes or, when run with test=True,
+// would make changes.
+func (s stateResult) changed() bool {
+	return len(s.Changes) != 0 || s.Result == nil
+}
+
+// failed returns the states that returned a result of false.
+func (r *stateRun) failed() []stateResult {
+	var failed []stateResult
+	for _, s := range r.States {
+		if s.failed() {
+			failed = append(failed, s)
+		}
+	}
+	return failed
+}
+
+// err returns an error describing any failed states, or nil if all states succeeded.
+func (r *stateRun) err() error {
+	failed := r.failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(failed))
+	for i, s := range failed {
+		ids[i] = s.ID
+	}
+	return fmt.Errorf("%d of %d states failed: %s", len(failed), len(r.States), strings.Join(ids, ", "))
+}
+
+// changed returns the states that made changes or would make changes.
+func (r *stateRun) changed() []stateResult {
+	var changed []stateResult
+	for _, s := range r.States {
+		if !s.failed() && s.changed() {
+			changed = append(changed, s)
+		}
+	}
+	return changed
+}
+
+// changesErr returns an error describing any states that made or would make
+// changes, or nil if no changes were reported.
+func (r *stateRun) changesErr() error {
+	changed := r.changed()
+	if len(changed) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(changed))
+	for i, s := range changed {
+		ids[i] = s.ID
+	}
+	if r.Test {
+		return fmt.Errorf("%d of %d states would make changes: %s", len(changed), len(r.States), strings.Join(ids, ", "))
+	}
+	return fmt.Errorf("%d of %d states made changes: %s", len(changed), len(r.States), strings.Join(ids, ", "))
+}
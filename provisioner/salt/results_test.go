@@ -0,0 +1,139 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStateRun(t *testing.T) {
+	output := `[WARNING ] a log line before the output
+{
+  "local": {
+    "file_|-motd_|-/etc/motd_|-managed": {
+      "__id__": "motd",
+      "name": "/etc/motd",
+      "__sls__": "base.motd",
+      "__run_num__": 1,
+      "result": true,
+      "comment": "File /etc/motd updated",
+      "changes": {"diff": "New file"},
+      "start_time": "10:00:00.000000",
+      "duration": 12.5
+    },
+    "pkg_|-vim_|-vim_|-installed": {
+      "__run_num__": 0,
+      "result": false,
+      "comment": ["The following packages failed to install:", "vim"],
+      "changes": {},
+      "duration": "3.25 ms"
+    },
+    "cmd_|-check_|-true_|-run": {
+      "__id__": "check",
+      "name": "true",
+      "__sls__": "base.check",
+      "__run_num__": 2,
+      "result": null,
+      "comment": "Command would have been executed",
+      "changes": {},
+      "duration": "7 ms"
+    }
+  }
+}`
+
+	run, err := parseStateRun("base", []byte(output))
+	if err != nil {
+		t.Fatalf("parseStateRun returned error: %s", err)
+	}
+	if run.Target != "base" {
+		t.Errorf("Target = %q; want base", run.Target)
+	}
+	if len(run.States) != 3 {
+		t.Fatalf("got %d states; want 3", len(run.States))
+	}
+
+	tests := []struct {
+		id       string
+		name     string
+		function string
+		comment  string
+		duration float64
+		failed   bool
+		changed  bool
+	}{
+		{"vim", "vim", "pkg.installed", "The following packages failed to install:\nvim", 3.25, true, false},
+		{"motd", "/etc/motd", "file.managed", "File /etc/motd updated", 12.5, false, true},
+		{"check", "true", "cmd.run", "Command would have been executed", 7, false, true},
+	}
+	for i, tt := range tests {
+		s := run.States[i]
+		if s.ID != tt.id || s.Name != tt.name || s.Function != tt.function {
+			t.Errorf("state %d = %s %s %s; want %s %s %s", i, s.ID, s.Name, s.Function, tt.id, tt.name, tt.function)
+		}
+		if s.Comment != tt.comment {
+			t.Errorf("state %d comment = %q; want %q", i, s.Comment, tt.comment)
+		}
+		if s.Duration != tt.duration {
+			t.Errorf("state %d duration = %g; want %g", i, s.Duration, tt.duration)
+		}
+		if s.failed() != tt.failed || s.changed() != tt.changed {
+			t.Errorf("state %d failed, changed = %t, %t; want %t, %t", i, s.failed(), s.changed(), tt.failed, tt.changed)
+		}
+	}
+
+	if err := run.err(); err == nil || err.Error() != "1 of 3 states failed: vim" {
+		t.Errorf("err() = %v", err)
+	}
+}
+
+func TestParseStateRunErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		wantErr string
+		wantRun bool
+	}{
+		{
+			name:    "error list",
+			output:  `{"local": ["Rendering SLS 'base:web' failed: mapping values are not allowed here", "No matching sls found for 'db' in env 'base'"]}`,
+			wantErr: "Rendering SLS 'base:web' failed: mapping values are not allowed here; No matching sls found for 'db' in env 'base'",
+			wantRun: true,
+		},
+		{
+			name:    "error string",
+			output:  `{"local": "'state.apply' is not available."}`,
+			wantErr: "'state.apply' is not available.",
+			wantRun: true,
+		},
+		{
+			name:    "unexpected value",
+			output:  `{"local": 42}`,
+			wantErr: "error decoding state results: ",
+			wantRun: true,
+		},
+		{
+			name:    "no JSON",
+			output:  "salt-call: command not found",
+			wantErr: "no JSON output found",
+		},
+		{
+			name:    "invalid JSON",
+			output:  `{"local": `,
+			wantErr: "error decoding salt-call output: unexpected EOF",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run, err := parseStateRun("web", []byte(tt.output))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("parseStateRun error = %v; want %q", err, tt.wantErr)
+			}
+			if (run != nil) != tt.wantRun {
+				t.Errorf("parseStateRun run = %+v; want run %t", run, tt.wantRun)
+			}
+		})
+	}
+}
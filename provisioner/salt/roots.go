@@ -0,0 +1,198 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// ----------------------------------------------------------------------------
+// File root, pillar root and minion configuration methods
+// ----------------------------------------------------------------------------
+func validateRootsConfig(roots []RootsConfig, cfg string) []error {
+	var errs []error
+
+	saltenvs := make(map[string]bool)
+	for _, r := range roots {
+		if r.Saltenv == "" {
+			errs = append(errs, fmt.Errorf("%s: saltenv must be specified", cfg))
+			continue
+		}
+		if saltenvs[r.Saltenv] {
+			errs = append(errs, fmt.Errorf("%s: saltenv %s is specified more than once", cfg, r.Saltenv))
+		}
+		saltenvs[r.Saltenv] = true
+
+		if len(r.Paths) == 0 {
+			errs = append(errs, fmt.Errorf("%s: at least one path must be specified for saltenv %s", cfg, r.Saltenv))
+		}
+		for _, dir := range r.Paths {
+			if err := validateDirConfig(dir, cfg); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs
+}
+
+// usesMinionConfig reports whether salt-call is run with a generated minion
+// configuration instead of the --file-root and --pillar-root arguments.
+func (p *Provisioner) usesMinionConfig() bool {
+	return len(p.config.FileRoots) > 0 || len(p.config.PillarRoots) > 0 ||
+		p.config.MinionConfigFile != "" || len(p.config.MinionConfig) > 0 || len(p.config.Formulas) > 0 ||
+		p.config.ExtensionModules != ""
+}
+
+// usesRootsDir reports whether any directories are uploaded to the roots directory.
+func (p *Provisioner) usesRootsDir() bool {
+	return len(p.config.FileRoots) > 0 || len(p.config.PillarRoots) > 0 || len(p.config.Formulas) > 0 ||
+		p.config.ExtensionModules != ""
+}
+
+// stateTrees returns the local directories that states can be applied from.
+func (p *Provisioner) stateTrees() []string {
+	var trees []string
+	if p.config.StateTree != "" {
+		trees = append(trees, p.config.StateTree)
+	}
+	for _, r := range p.config.FileRoots {
+		trees = append(trees, r.Paths...)
+	}
+	for _, f := range p.formulas {
+		trees = append(trees, f.Dir)
+	}
+	return trees
+}
+
+// remoteRootDir returns the directory on the target system that a local file
+// or pillar root directory is uploaded to.
+func (p *Provisioner) remoteRootDir(kind string, saltenv string, index int) string {
+	return path.Join(filepath.ToSlash(p.getConfig("configRootsDir")), kind, saltenv, strconv.Itoa(index))
+}
+
+func (p *Provisioner) uploadRoots(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator) error {
+	for _, kind := range []string{"file", "pillar"} {
+		roots := p.config.FileRoots
+		if kind == "pillar" {
+			roots = p.config.PillarRoots
+		}
+		for _, r := range roots {
+			for i, dir := range r.Paths {
+				ui.Say(fmt.Sprintf("Uploading %s root %s for saltenv %s...", kind, dir, r.Saltenv))
+				if err := p.uploadDir(ctx, ui, comm, p.remoteRootDir(kind, r.Saltenv, i), dir); err != nil {
+					return fmt.Errorf("error uploading %s_roots: %s", kind, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// createMinionConfig returns a minion configuration declaring the state and
+// pillar directories together with any file and pillar roots, merged with the
+// minion_config settings. The configuration is serialized as JSON, which Salt
+// reads as YAML.
+func (p *Provisioner) createMinionConfig() ([]byte, error) {
+	fileRoots := map[string][]string{
+		"base": {p.config.StateDir},
+	}
+	for _, r := range p.config.FileRoots {
+		for i := range r.Paths {
+			fileRoots[r.Saltenv] = append(fileRoots[r.Saltenv], p.remoteRootDir("file", r.Saltenv, i))
+		}
+	}
+	for i := range p.config.Formulas {
+		fileRoots["base"] = append(fileRoots["base"], p.remoteRootDir("formula", "base", i))
+	}
+	if p.config.ExtensionModules != "" {
+		fileRoots["base"] = append(fileRoots["base"], p.remoteRootDir("extension_modules", "base", 0))
+	}
+
+	pillarRoots := make(map[string][]string)
+	if p.usesPillarDir() {
+		pillarRoots["base"] = []string{p.config.PillarDir}
+	}
+	for _, r := range p.config.PillarRoots {
+		for i := range r.Paths {
+			pillarRoots[r.Saltenv] = append(pillarRoots[r.Saltenv], p.remoteRootDir("pillar", r.Saltenv, i))
+		}
+	}
+
+	minionConfig := map[string]interface{}{
+		"file_client": "local",
+		"file_roots":  fileRoots,
+	}
+	if len(pillarRoots) > 0 {
+		minionConfig["pillar_roots"] = pillarRoots
+	}
+
+	for k, v := range decodePillarValues(p.config.MinionConfig) {
+		if k == "file_roots" || k == "pillar_roots" {
+			roots, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("minion_config: %s must be an object of saltenvs to lists of directories", k)
+			}
+			merged := fileRoots
+			if k == "pillar_roots" {
+				merged = pillarRoots
+			}
+			for saltenv, dirs := range roots {
+				list, ok := dirs.([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("minion_config: %s for saltenv %s must be a list of directories", k, saltenv)
+				}
+				for _, dir := range list {
+					merged[saltenv] = append(merged[saltenv], fmt.Sprint(dir))
+				}
+			}
+			minionConfig[k] = merged
+			continue
+		}
+		minionConfig[k] = v
+	}
+
+	return json.MarshalIndent(minionConfig, "", "  ")
+}
+
+// uploadMinionConfig writes the minion configuration to the configuration
+// directory on the target system, and returns the directory. The contents of
+// minion_config_file, if any, are uploaded as the minion file, and the
+// generated configuration is uploaded to minion.d so that Salt merges it over
+// the minion file.
+func (p *Provisioner) uploadMinionConfig(ctx context.Context, ui packersdk.Ui, comm packersdk.Communicator) (string, error) {
+	configDir := filepath.ToSlash(p.getConfig("configConfigDir"))
+	minionConfig, err := p.createMinionConfig()
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.createDir(ctx, ui, comm, path.Join(configDir, "minion.d")); err != nil {
+		return "", err
+	}
+
+	minionFile := path.Join(configDir, "minion")
+	if p.config.MinionConfigFile != "" {
+		if err := p.uploadFile(ui, comm, minionFile, p.config.MinionConfigFile); err != nil {
+			return "", err
+		}
+	} else if err := comm.Upload(minionFile, strings.NewReader(""), nil); err != nil {
+		return "", err
+	}
+
+	configFile := path.Join(configDir, "minion.d", "packer.conf")
+	ui.Say(fmt.Sprintf("Uploading minion configuration to %s", configFile))
+	if err := comm.Upload(configFile, strings.NewReader(string(minionConfig)+"\n"), nil); err != nil {
+		return "", err
+	}
+	return configDir, nil
+}
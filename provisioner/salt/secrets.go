@@ -0,0 +1,207 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// ----------------------------------------------------------------------------
+// Secret redaction methods
+// ----------------------------------------------------------------------------
+func (p *Provisioner) validateSecretConfig() []error {
+	var errs []error
+
+	_, envVars := p.getEnvVars()
+	for _, k := range p.config.SensitiveEnvVars {
+		if _, ok := envVars[k]; !ok {
+			errs = append(errs, fmt.Errorf("sensitive_environment_vars: %s is not defined in environment_vars or env", k))
+		}
+	}
+	for _, k := range p.config.SensitivePillarKeys {
+		if len(p.pillarValues(k)) == 0 {
+			errs = append(errs, fmt.Errorf("sensitive_pillar_keys: %s is not defined in pillar", k))
+		}
+	}
+
+	return errs
+}
+
+// registerSecrets adds the values of Packer sensitive variables, the sudo password,
+// sensitive environment variables and sensitive pillar keys to the log secret filter,
+// together with the escaped forms in which they appear in salt-call commands.
+func (p *Provisioner) registerSecrets() {
+	var secrets []string
+
+	for _, name := range p.config.PackerSensitiveVars {
+		if v, ok := p.config.PackerUserVars[name]; ok {
+			secrets = append(secrets, v)
+		}
+	}
+
+	if p.config.SudoPassword != "" {
+		secrets = append(secrets, p.config.SudoPassword)
+	}
+
+	_, envVars := p.getEnvVars()
+	for _, k := range p.config.SensitiveEnvVars {
+		if v, ok := envVars[k]; ok {
+			secrets = append(secrets, v)
+		}
+	}
+
+	for _, k := range p.config.SensitivePillarKeys {
+		for _, v := range p.pillarValues(k) {
+			secrets = append(secrets, v)
+			secrets = append(secrets, pillarLeafValues(decodePillarValues(map[string]string{k: v})[k])...)
+		}
+	}
+
+	var filtered []string
+	for _, s := range secrets {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		filtered = append(filtered, s, escapeSecret(s), escapeWindowsArg(s), escapePowerShellString(s))
+		if encoded, err := json.Marshal(s); err == nil {
+			trimmed := strings.Trim(string(encoded), `"`)
+			filtered = append(filtered, trimmed, escapeWindowsArg(escapeCmdJSON(trimmed)))
+		}
+	}
+	packersdk.LogSecretFilter.Set(filtered...)
+}
+
+// pillarValues returns the values of a key in the pillar option and in the pillar of each state step.
+func (p *Provisioner) pillarValues(key string) []string {
+	var values []string
+	if v, ok := p.config.Pillar[key]; ok {
+		values = append(values, v)
+	}
+	for _, step := range p.config.Steps {
+		if v, ok := step.Pillar[key]; ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// pillarLeafValues returns every string value within decoded pillar data.
+func pillarLeafValues(value interface{}) []string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var leaves []string
+		for _, e := range v {
+			leaves = append(leaves, pillarLeafValues(e)...)
+		}
+		return leaves
+	case []interface{}:
+		var leaves []string
+		for _, e := range v {
+			leaves = append(leaves, pillarLeafValues(e)...)
+		}
+		return leaves
+	case string:
+		return []string{v}
+	default:
+		// Numbers, booleans and nulls are too common to be masked
+		return nil
+	}
+}
+
+// escapeSecret returns a value escaped for use within single quotes, matching
+// the escaping applied to environment variables and pillar data on Linux.
+func escapeSecret(value string) string {
+	return strings.Replace(value, "'", `'"'"'`, -1)
+}
+
+// filterSecrets replaces any registered secrets in the given text.
+func filterSecrets(text string) string {
+	return packersdk.LogSecretFilter.FilterString(text)
+}
+
+// filterStateRuns returns copies of the runs with any registered secrets masked
+// in their text. Reports are masked before they are encoded, as the encoded form
+// of a secret may no longer match, for example after XML or HTML escaping.
+func filterStateRuns(runs []*stateRun) []*stateRun {
+	filtered := make([]*stateRun, len(runs))
+	for i, run := range runs {
+		r := *run
+		r.Target = filterSecrets(r.Target)
+		r.Error = filterSecrets(r.Error)
+		r.States = make([]stateResult, len(run.States))
+		for j, s := range run.States {
+			s.ID = filterSecrets(s.ID)
+			s.Name = filterSecrets(s.Name)
+			s.Function = filterSecrets(s.Function)
+			s.SLS = filterSecrets(s.SLS)
+			s.File = filterSecrets(s.File)
+			s.Comment = filterSecrets(s.Comment)
+			if s.Changes != nil {
+				s.Changes = filterValue(s.Changes).(map[string]interface{})
+			}
+			r.States[j] = s
+		}
+		filtered[i] = &r
+	}
+	return filtered
+}
+
+// filterValue returns a copy of decoded JSON data with any registered secrets
+// masked in its keys and string values.
+func filterValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return filterSecrets(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[filterSecrets(k)] = filterValue(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = filterValue(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// secretFilterUi masks any registered secrets in the messages it passes to the wrapped Ui.
+type secretFilterUi struct {
+	packersdk.Ui
+}
+
+func (u *secretFilterUi) Say(message string) {
+	u.Ui.Say(filterSecrets(message))
+}
+
+func (u *secretFilterUi) Sayf(message string, args ...any) {
+	u.Ui.Say(filterSecrets(fmt.Sprintf(message, args...)))
+}
+
+func (u *secretFilterUi) Message(message string) {
+	u.Ui.Message(filterSecrets(message))
+}
+
+func (u *secretFilterUi) Error(message string) {
+	u.Ui.Error(filterSecrets(message))
+}
+
+func (u *secretFilterUi) Errorf(message string, args ...any) {
+	u.Ui.Error(filterSecrets(fmt.Sprintf(message, args...)))
+}
+
+func (u *secretFilterUi) Machine(t string, args ...string) {
+	for i, arg := range args {
+		args[i] = filterSecrets(arg)
+	}
+	u.Ui.Machine(t, args...)
+}
@@ -0,0 +1,38 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPillarLeafValues(t *testing.T) {
+	value := decodePillarValues(map[string]string{
+		"db": `{"password":"s3cret","replicas":1,"tls":true,"hosts":["db1",2],"extra":null}`,
+	})["db"]
+
+	leaves := pillarLeafValues(value)
+	sort.Strings(leaves)
+	expected := []string{"db1", "s3cret"}
+	if !reflect.DeepEqual(leaves, expected) {
+		t.Fatalf("expected %v, got %v", expected, leaves)
+	}
+}
+
+func TestRegisterSecretsPillarNumbers(t *testing.T) {
+	p := &Provisioner{}
+	p.config.Pillar = map[string]string{"db": `{"password":"pillar-s3cret","replicas":1}`}
+	p.config.SensitivePillarKeys = []string{"db"}
+	p.registerSecrets()
+
+	summary := "Succeeded: 12 (changed=1) Total run time: 10.5 s"
+	if got := filterSecrets(summary); got != summary {
+		t.Fatalf("expected numbers to be left unmasked, got %q", got)
+	}
+	if got := filterSecrets("password is pillar-s3cret"); got != "password is <sensitive>" {
+		t.Fatalf("expected the password to be masked, got %q", got)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// slsFile is a local state or pillar file together with its location on the
+// target system, relative to the remote file root.
+type slsFile struct {
+	// The path of the file on the local system
+	Source string
+	// The slash separated path of the file relative to the remote file root
+	Path string
+	// The dotted SLS name used to reference the file, for example "web.nginx"
+	Name string
+}
+
+// ----------------------------------------------------------------------------
+// SLS resolution methods
+// ----------------------------------------------------------------------------
+
+// resolveFiles maps local files to their paths relative to the remote file root.
+// The local directory corresponding to the file root is root or, if root is
+// empty, the deepest directory containing all of the files.
+func resolveFiles(files []string, root string, cfg string) ([]slsFile, []error) {
+	var errs []error
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	sources := make([]string, len(files))
+	anchors := make([]string, len(files))
+	for i, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return nil, []error{fmt.Errorf("%s: %s invalid: %s", cfg, f, err)}
+		}
+		sources[i] = abs
+		// An init.sls file is referenced by its directory, so that directory
+		// must remain below the file root
+		anchors[i] = abs
+		if filepath.Base(abs) == "init.sls" {
+			anchors[i] = filepath.Dir(abs)
+		}
+	}
+
+	if root == "" {
+		root = commonDir(anchors)
+	} else {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, []error{fmt.Errorf("%s_root: %s invalid: %s", cfg, root, err)}
+		}
+		root = abs
+	}
+
+	resolved := make([]slsFile, 0, len(files))
+	paths := make(map[string]string)
+	for i, source := range sources {
+		if !withinDir(root, source) {
+			errs = append(errs, fmt.Errorf("%s: %s is not within %s", cfg, files[i], root))
+			continue
+		}
+		rel, _ := filepath.Rel(root, source)
+		rel = filepath.ToSlash(rel)
+		if other, ok := paths[rel]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s and %s would be uploaded to the same path", cfg, other, files[i]))
+			continue
+		}
+		paths[rel] = files[i]
+		resolved = append(resolved, slsFile{Source: files[i], Path: rel})
+	}
+
+	return resolved, errs
+}
+
+// resolveStateFiles maps local state files to their paths relative to the remote
+// file root and to the SLS names used to apply them. If root is empty, the
+// working directory is used, so that the SLS name of a file does not depend on
+// the other files that are listed.
+func resolveStateFiles(files []string, root string) ([]slsFile, []error) {
+	if root == "" {
+		cwd, errs := workingDirRoot(files, "state_files")
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		root = cwd
+	}
+
+	resolved, errs := resolveFiles(files, root, "state_files")
+	errs = append(errs, assignSlsNames(resolved, "state_files", true)...)
+	return resolved, errs
+}
+
+// resolvePillarFiles maps local pillar files to their paths relative to the
+// remote pillar root and to the SLS names assigned by a generated top file. If
+// root is empty, the pillar tree is used so that pillar files replace the files
+// at the same path within it, or the working directory if there is no pillar tree.
+func resolvePillarFiles(files []string, root string, tree string) ([]slsFile, []error) {
+	switch {
+	case root != "":
+	case tree != "":
+		abs, err := filepath.Abs(tree)
+		if err != nil {
+			return nil, []error{fmt.Errorf("pillar_tree: %s invalid: %s", tree, err)}
+		}
+		var errs []error
+		for _, f := range files {
+			if source, err := filepath.Abs(f); err == nil && !withinDir(abs, source) {
+				errs = append(errs, fmt.Errorf("pillar_files: %s is not within pillar_tree, pillar_files_root must be set to a directory containing all of the pillar files", f))
+			}
+		}
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		root = abs
+	default:
+		cwd, errs := workingDirRoot(files, "pillar_files")
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		root = cwd
+	}
+
+	resolved, errs := resolveFiles(files, root, "pillar_files")
+	errs = append(errs, assignSlsNames(resolved, "pillar_files", false)...)
+	return resolved, errs
+}
+
+// workingDirRoot returns the working directory for use as the file root of the
+// given files, which must all be within it.
+func workingDirRoot(files []string, cfg string) (string, []error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", []error{fmt.Errorf("%s: unable to determine the working directory: %s", cfg, err)}
+	}
+	var errs []error
+	for _, f := range files {
+		if abs, err := filepath.Abs(f); err == nil && !withinDir(cwd, abs) {
+			errs = append(errs, fmt.Errorf("%s: %s is not within the working directory, %s_root must be set to a directory containing all of the %s", cfg, f, cfg, strings.Replace(cfg, "_", " ", 1)))
+		}
+	}
+	return cwd, errs
+}
+
+// assignSlsNames sets the SLS name of each resolved file. Unless every file must
+// be an SLS file, files without a .sls extension are left without a name.
+func assignSlsNames(files []slsFile, cfg string, requireSls bool) []error {
+	var errs []error
+	names := make(map[string]string)
+	for i := range files {
+		f := &files[i]
+		if !requireSls && path.Ext(f.Path) != ".sls" {
+			continue
+		}
+		name, err := slsName(f.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s %s", cfg, f.Source, err))
+			continue
+		}
+		if other, ok := names[name]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s and %s both resolve to the SLS name %s", cfg, other, f.Source, name))
+			continue
+		}
+		names[name] = f.Source
+		f.Name = name
+	}
+	return errs
+}
+
+// resolveTreeStates finds the files within the local state trees that define
+// the given SLS names. Each name must be defined by <name>.sls or <name>/init.sls.
+func resolveTreeStates(trees []string, names []string) ([]slsFile, []error) {
+	var errs []error
+	var resolved []slsFile
+
+	for _, name := range names {
+		parts := strings.Split(name, ".")
+		valid := true
+		for _, part := range parts {
+			if part == "" || strings.ContainsAny(part, `/\`) {
+				valid = false
+			}
+		}
+		if !valid {
+			errs = append(errs, fmt.Errorf("states: %q is not a valid SLS name", name))
+			continue
+		}
+
+		base := path.Join(parts...)
+		found := false
+		for _, tree := range trees {
+			for _, rel := range []string{base + ".sls", path.Join(base, "init.sls")} {
+				source := filepath.Join(tree, filepath.FromSlash(rel))
+				if info, err := os.Stat(source); err == nil && !info.IsDir() {
+					resolved = append(resolved, slsFile{Source: source, Path: rel, Name: name})
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("states: %s not found in state_tree or file_roots, expected %s.sls or %s/init.sls", name, base, base))
+		}
+	}
+
+	return resolved, errs
+}
+
+// slsName returns the dotted SLS name for a slash separated path relative to the
+// file root. A file named init.sls is referenced by the name of its directory.
+func slsName(p string) (string, error) {
+	if path.Ext(p) != ".sls" {
+		return "", fmt.Errorf("must have a .sls extension")
+	}
+
+	p = strings.TrimSuffix(p, ".sls")
+	if path.Base(p) == "init" {
+		p = path.Dir(p)
+		if p == "." {
+			return "", fmt.Errorf("cannot be applied as init.sls must be within a directory under the file root")
+		}
+	}
+
+	parts := strings.Split(p, "/")
+	for _, part := range parts {
+		if part == "" || strings.Contains(part, ".") {
+			return "", fmt.Errorf("cannot be applied as %q is not a valid SLS name component", part)
+		}
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// commonDir returns the deepest directory that contains all of the given absolute paths.
+func commonDir(paths []string) string {
+	dir := filepath.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for !withinDir(dir, p) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return dir
+}
+
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// attributeStates records the state file that each state in a run was defined
+// in, using the SLS name reported by Salt.
+func (p *Provisioner) attributeStates(run *stateRun) {
+	files := make(map[string]string)
+	for _, f := range p.stateFiles {
+		files[f.Name] = f.Source
+	}
+	for _, f := range p.treeStates {
+		files[f.Name] = f.Source
+	}
+	for _, f := range p.inlineStates {
+		files[f.Name] = f.Source
+	}
+	for i := range run.States {
+		run.States[i].File = files[run.States[i].SLS]
+	}
+}
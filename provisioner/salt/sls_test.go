@@ -0,0 +1,283 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSlsName(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "nginx.sls", want: "nginx"},
+		{path: "web/nginx.sls", want: "web.nginx"},
+		{path: "web/init.sls", want: "web"},
+		{path: "web/nginx/init.sls", want: "web.nginx"},
+		{path: "web/nginx_conf.sls", want: "web.nginx_conf"},
+		{path: "init.sls", wantErr: true},
+		{path: "nginx.yaml", wantErr: true},
+		{path: "nginx", wantErr: true},
+		{path: "my.slsx.sls", wantErr: true},
+		{path: "web.d/nginx.sls", wantErr: true},
+		{path: "web//nginx.sls", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := slsName(tt.path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("slsName(%q) = %q; want an error", tt.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("slsName(%q) returned error: %s", tt.path, err)
+		} else if got != tt.want {
+			t.Errorf("slsName(%q) = %q; want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestResolveStateFiles(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, dir, map[string]string{
+		"states/top.sls":         "",
+		"states/db.sls":          "",
+		"states/web/nginx.sls":   "",
+		"states/web/init.sls":    "",
+		"states/web/my.conf.sls": "",
+		"states/init.sls":        "",
+		"states/web.sls":         "",
+		"other/extra.sls":        "",
+	})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "states")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	type resolved struct {
+		path string
+		name string
+	}
+	tests := []struct {
+		name    string
+		files   []string
+		root    string
+		want    []resolved
+		wantErr bool
+	}{
+		{
+			name:  "working directory root",
+			files: []string{"web/nginx.sls"},
+			want:  []resolved{{"web/nginx.sls", "web.nginx"}},
+		},
+		{
+			name:  "name does not depend on other files",
+			files: []string{"web/nginx.sls", "db.sls"},
+			want:  []resolved{{"web/nginx.sls", "web.nginx"}, {"db.sls", "db"}},
+		},
+		{
+			name:  "init file",
+			files: []string{"web/init.sls"},
+			want:  []resolved{{"web/init.sls", "web"}},
+		},
+		{
+			name:  "absolute path within working directory",
+			files: []string{filepath.Join(dir, "states", "web", "nginx.sls")},
+			want:  []resolved{{"web/nginx.sls", "web.nginx"}},
+		},
+		{
+			name:  "dot segments within working directory",
+			files: []string{"web/../db.sls"},
+			want:  []resolved{{"db.sls", "db"}},
+		},
+		{
+			name:    "parent directory without root",
+			files:   []string{"../other/extra.sls"},
+			wantErr: true,
+		},
+		{
+			name:    "absolute path outside working directory without root",
+			files:   []string{filepath.Join(dir, "other", "extra.sls")},
+			wantErr: true,
+		},
+		{
+			name:  "parent directory with root",
+			files: []string{"../other/extra.sls", "web/nginx.sls"},
+			root:  dir,
+			want:  []resolved{{"other/extra.sls", "other.extra"}, {"states/web/nginx.sls", "states.web.nginx"}},
+		},
+		{
+			name:  "relative root",
+			files: []string{"web/nginx.sls"},
+			root:  "web",
+			want:  []resolved{{"nginx.sls", "nginx"}},
+		},
+		{
+			name:    "file outside of root",
+			files:   []string{"db.sls"},
+			root:    "web",
+			wantErr: true,
+		},
+		{
+			name:    "init file at the root",
+			files:   []string{"init.sls"},
+			wantErr: true,
+		},
+		{
+			name:    "dotted name",
+			files:   []string{"web/my.conf.sls"},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate SLS name",
+			files:   []string{"web.sls", "web/init.sls"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := resolveStateFiles(tt.files, tt.root)
+			if tt.wantErr {
+				if len(errs) == 0 {
+					t.Errorf("resolveStateFiles(%q, %q) = %+v; want an error", tt.files, tt.root, got)
+				}
+				return
+			}
+			if len(errs) != 0 {
+				t.Fatalf("resolveStateFiles(%q, %q) returned errors: %v", tt.files, tt.root, errs)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("resolveStateFiles(%q, %q) = %+v; want %+v", tt.files, tt.root, got, tt.want)
+			}
+			for i, f := range got {
+				if f.Path != tt.want[i].path || f.Name != tt.want[i].name {
+					t.Errorf("file %d = %s (%s); want %s (%s)", i, f.Path, f.Name, tt.want[i].path, tt.want[i].name)
+				}
+				if _, err := os.Stat(f.Source); err != nil {
+					t.Errorf("file %d source %s: %s", i, f.Source, err)
+				}
+			}
+		})
+	}
+}
+
+func TestResolvePillarFiles(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, dir, map[string]string{
+		"pillar/common.sls":          "",
+		"pillar/web/override.sls":    "",
+		"pillar/web/settings.yaml":   "",
+		"pillar/app.settings.sls":    "",
+		"overrides/web/override.sls": "",
+	})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	type resolved struct {
+		path string
+		name string
+	}
+	tests := []struct {
+		name    string
+		files   []string
+		root    string
+		tree    string
+		want    []resolved
+		wantErr bool
+	}{
+		{
+			name:  "working directory root",
+			files: []string{"pillar/web/override.sls"},
+			want:  []resolved{{"pillar/web/override.sls", "pillar.web.override"}},
+		},
+		{
+			name:  "pillar tree root",
+			files: []string{"pillar/web/override.sls"},
+			tree:  "pillar",
+			want:  []resolved{{"web/override.sls", "web.override"}},
+		},
+		{
+			name:  "path does not depend on other files",
+			files: []string{"pillar/web/override.sls", "pillar/common.sls"},
+			tree:  "pillar",
+			want:  []resolved{{"web/override.sls", "web.override"}, {"common.sls", "common"}},
+		},
+		{
+			name:    "file outside of pillar tree",
+			files:   []string{"overrides/web/override.sls"},
+			tree:    "pillar",
+			wantErr: true,
+		},
+		{
+			name:  "root takes precedence over pillar tree",
+			files: []string{"overrides/web/override.sls"},
+			root:  "overrides",
+			tree:  "pillar",
+			want:  []resolved{{"web/override.sls", "web.override"}},
+		},
+		{
+			name:  "file without an SLS extension",
+			files: []string{"pillar/web/settings.yaml"},
+			tree:  "pillar",
+			want:  []resolved{{"web/settings.yaml", ""}},
+		},
+		{
+			name:    "dotted name",
+			files:   []string{"pillar/app.settings.sls"},
+			tree:    "pillar",
+			wantErr: true,
+		},
+		{
+			name:    "outside of working directory",
+			files:   []string{filepath.Join(filepath.Dir(dir), "other.sls")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := resolvePillarFiles(tt.files, tt.root, tt.tree)
+			if tt.wantErr {
+				if len(errs) == 0 {
+					t.Errorf("resolvePillarFiles(%q, %q, %q) = %+v; want an error", tt.files, tt.root, tt.tree, got)
+				}
+				return
+			}
+			if len(errs) != 0 {
+				t.Fatalf("resolvePillarFiles(%q, %q, %q) returned errors: %v", tt.files, tt.root, tt.tree, errs)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("resolvePillarFiles(%q, %q, %q) = %+v; want %+v", tt.files, tt.root, tt.tree, got, tt.want)
+			}
+			for i, f := range got {
+				if f.Path != tt.want[i].path || f.Name != tt.want[i].name {
+					t.Errorf("file %d = %s (%s); want %s (%s)", i, f.Path, f.Name, tt.want[i].path, tt.want[i].name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// State step methods
+// ----------------------------------------------------------------------------
+func (p *Provisioner) validateStateSteps() []error {
+	var errs []error
+
+	for i, step := range p.config.Steps {
+		for _, targetOS := range step.OnlyOS {
+			if targetOS != "linux" && targetOS != "windows" {
+				errs = append(errs, fmt.Errorf("state %d: permitted value for only_os is one of: linux, windows", i+1))
+			}
+		}
+		if step.Retries < 0 {
+			errs = append(errs, fmt.Errorf("state %d: retries must not be negative", i+1))
+		}
+		if step.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("state %d: timeout must not be negative", i+1))
+		}
+	}
+
+	return errs
+}
+
+// resolveStates finds the files that define the states applied by the states
+// option and by state blocks.
+func (p *Provisioner) resolveStates() []error {
+	var errs []error
+
+	p.treeStates = nil
+	if len(p.config.States) != 0 {
+		resolved, stateErrs := resolveTreeStates(p.stateTrees(), p.config.States)
+		errs = append(errs, stateErrs...)
+		p.treeStates = append(p.treeStates, resolved...)
+	}
+
+	stateNames := make(map[string]bool)
+	for _, f := range p.stateFiles {
+		stateNames[f.Name] = true
+	}
+	for _, f := range p.inlineStates {
+		stateNames[f.Name] = true
+	}
+
+	for i, step := range p.config.Steps {
+		if step.Name == "" {
+			continue
+		}
+		var names []string
+		for _, name := range strings.Split(step.Name, ",") {
+			if !stateNames[name] {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		if trees := p.stateTrees(); len(trees) > 0 {
+			resolved, stateErrs := resolveTreeStates(trees, names)
+			for _, err := range stateErrs {
+				errs = append(errs, fmt.Errorf("state %d: %s", i+1, strings.TrimPrefix(err.Error(), "states: ")))
+			}
+			p.treeStates = append(p.treeStates, resolved...)
+		} else {
+			for _, name := range names {
+				errs = append(errs, fmt.Errorf("state %d: %s is not one of the state_files or inline_states", i+1, name))
+			}
+		}
+	}
+
+	return errs
+}
+
+// target returns the name of the states applied by the step for use in output and reports.
+func (s StateConfig) target() string {
+	if s.Name == "" {
+		return "highstate"
+	}
+	return s.Name
+}
+
+// runsOn reports whether the step runs on the given target OS.
+func (s StateConfig) runsOn(targetOS string) bool {
+	if len(s.OnlyOS) == 0 {
+		return true
+	}
+	for _, o := range s.OnlyOS {
+		if o == targetOS {
+			return true
+		}
+	}
+	return false
+}
+
+// testMode reports whether the step is applied with test=True.
+func (s StateConfig) testMode(defaultTest bool) bool {
+	if s.Test.ToBoolPointer() != nil {
+		return s.Test.True()
+	}
+	return defaultTest
+}
@@ -0,0 +1,186 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// slowestStateCount is the number of slowest states listed in a summary.
+const slowestStateCount = 10
+
+// ----------------------------------------------------------------------------
+// State output and summary methods
+// ----------------------------------------------------------------------------
+func (p *Provisioner) printStateRun(ui packersdk.Ui, run *stateRun) {
+	for _, s := range run.States {
+		if !p.config.StateVerbose && !s.failed() && !s.changed() {
+			continue
+		}
+
+		full := false
+		switch p.config.StateOutput {
+		case "full":
+			full = true
+		case "mixed":
+			full = s.failed()
+		case "changes":
+			full = s.failed() || s.changed()
+		}
+
+		if full {
+			printStateFull(ui, s)
+		} else {
+			printStateTerse(ui, s)
+		}
+	}
+
+	printStateSummary(ui, run)
+}
+
+func printStateTerse(ui packersdk.Ui, s stateResult) {
+	line := fmt.Sprintf("Name: %s - Function: %s - Result: %s - Started: %s - Duration: %.3f ms",
+		s.Name, s.Function, s.resultString(), s.StartTime, s.Duration)
+	if s.failed() {
+		ui.Error(line)
+	} else {
+		ui.Message(line)
+	}
+}
+
+func printStateFull(ui packersdk.Ui, s stateResult) {
+	lines := []string{
+		"----------",
+		fmt.Sprintf("          ID: %s", s.ID),
+		fmt.Sprintf("    Function: %s", s.Function),
+		fmt.Sprintf("        Name: %s", s.Name),
+	}
+	if s.File != "" {
+		lines = append(lines, fmt.Sprintf("        File: %s", s.File))
+	}
+	lines = append(lines,
+		fmt.Sprintf("      Result: %s", s.resultString()),
+		fmt.Sprintf("     Comment: %s", indentLines(s.Comment, "              ")),
+		fmt.Sprintf("     Started: %s", s.StartTime),
+		fmt.Sprintf("    Duration: %.3f ms", s.Duration),
+	)
+	if len(s.Changes) == 0 {
+		lines = append(lines, "     Changes:")
+	} else {
+		changes, _ := json.MarshalIndent(s.Changes, "              ", "  ")
+		lines = append(lines, fmt.Sprintf("     Changes: %s", changes))
+	}
+
+	if s.failed() {
+		ui.Error(strings.Join(lines, "\n"))
+	} else {
+		ui.Message(strings.Join(lines, "\n"))
+	}
+}
+
+func printStateSummary(ui packersdk.Ui, run *stateRun) {
+	var succeeded, changed, failed int
+	var total float64
+	for _, s := range run.States {
+		if s.failed() {
+			failed++
+		} else {
+			succeeded++
+		}
+		if !s.failed() && s.changed() {
+			changed++
+		}
+		total += s.Duration
+	}
+
+	if run.Test {
+		ui.Say(fmt.Sprintf("Summary for %s (test=True)", run.Target))
+		ui.Message(fmt.Sprintf("Succeeded: %d (unchanged=%d, changed=%d)", succeeded, succeeded-changed, changed))
+	} else {
+		ui.Say(fmt.Sprintf("Summary for %s", run.Target))
+		ui.Message(fmt.Sprintf("Succeeded: %d (changed=%d)", succeeded, changed))
+	}
+	ui.Message(fmt.Sprintf("Failed:    %d", failed))
+	ui.Message(fmt.Sprintf("Total states run: %d", len(run.States)))
+	ui.Message(fmt.Sprintf("Total run time: %.3f s", total/1000))
+
+	if len(run.States) == 0 {
+		return
+	}
+
+	slowest := make([]stateResult, len(run.States))
+	copy(slowest, run.States)
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].Duration > slowest[j].Duration
+	})
+	if len(slowest) > slowestStateCount {
+		slowest = slowest[:slowestStateCount]
+	}
+
+	ui.Message("Slowest states:")
+	for _, s := range slowest {
+		ui.Message(fmt.Sprintf("  %10.3f ms  %s (%s)", s.Duration, s.ID, s.Function))
+	}
+
+	for _, s := range run.failed() {
+		if s.File != "" {
+			ui.Error(fmt.Sprintf("State %s (%s) in %s failed: %s", s.ID, s.Function, s.File, s.Comment))
+		} else {
+			ui.Error(fmt.Sprintf("State %s (%s) failed: %s", s.ID, s.Function, s.Comment))
+		}
+	}
+}
+
+func (s stateResult) resultString() string {
+	switch {
+	case s.Result == nil:
+		return "None"
+	case *s.Result:
+		if len(s.Changes) != 0 {
+			return "Changed"
+		}
+		return "Clean"
+	default:
+		return "Failed"
+	}
+}
+
+func indentLines(text string, indent string) string {
+	return strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n"+indent)
+}
+
+// uiLineWriter writes each complete line it receives to the Ui as an error message.
+type uiLineWriter struct {
+	ui  packersdk.Ui
+	buf []byte
+}
+
+func (w *uiLineWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimRight(string(w.buf[:i]), "\r"); line != "" {
+			w.ui.Error(line)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes any remaining partial line to the Ui.
+func (w *uiLineWriter) Flush() {
+	if line := strings.TrimSpace(string(w.buf)); line != "" {
+		w.ui.Error(line)
+	}
+	w.buf = nil
+}
@@ -0,0 +1,311 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package salt
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// jinjaPlaceholder replaces Jinja expressions when SLS files are validated.
+const jinjaPlaceholder = "__jinja__"
+
+// yamlRenderers are the renderers of an SLS file that can be validated as YAML.
+var yamlRenderers = map[string]bool{
+	"jinja": true,
+	"yaml":  true,
+	"json":  true,
+	"gpg":   true,
+}
+
+// slsSource is an SLS file to validate, together with the local state trees
+// that its includes are resolved against.
+type slsSource struct {
+	slsFile
+	// The content of the file, if it does not exist on the local system
+	Content string
+	// The local directories that includes are resolved against
+	Trees []string
+	// Whether the includes of the file are checked
+	CheckIncludes bool
+}
+
+// ----------------------------------------------------------------------------
+// SLS validation methods
+// ----------------------------------------------------------------------------
+
+// validateSLS parses the state and pillar files on the local system as YAML
+// and checks that a top file exists when a highstate is applied from the state
+// tree, and that the includes of states can be resolved.
+func (p *Provisioner) validateSLS() []error {
+	var errs []error
+
+	if p.config.StateTree != "" && p.appliesHighstate() {
+		if _, err := os.Stat(filepath.Join(p.config.StateTree, "top.sls")); err != nil {
+			errs = append(errs, fmt.Errorf("validate_sls: state_tree %s has no top.sls file for a highstate", p.config.StateTree))
+		}
+	}
+
+	// The includes of states cannot be resolved until formulas are fetched
+	checkIncludes := len(p.config.Formulas) == 0
+
+	var sources []slsSource
+	for _, f := range p.stateFiles {
+		sources = append(sources, slsSource{slsFile: f, CheckIncludes: checkIncludes})
+	}
+	for _, f := range p.inlineStates {
+		sources = append(sources, slsSource{slsFile: f, Content: p.config.InlineStates[f.Name], CheckIncludes: checkIncludes})
+	}
+	for _, tree := range p.stateTrees() {
+		sources = append(sources, p.treeSources(tree, p.stateTrees(), checkIncludes)...)
+	}
+	for _, f := range p.pillarFiles {
+		sources = append(sources, slsSource{slsFile: f})
+	}
+	if p.config.PillarTree != "" {
+		sources = append(sources, p.treeSources(p.config.PillarTree, nil, false)...)
+	}
+	for _, r := range p.config.PillarRoots {
+		for _, dir := range r.Paths {
+			sources = append(sources, p.treeSources(dir, nil, false)...)
+		}
+	}
+
+	for _, s := range sources {
+		errs = append(errs, p.validateSLSSource(s)...)
+	}
+
+	return errs
+}
+
+// appliesHighstate reports whether a highstate is applied.
+func (p *Provisioner) appliesHighstate() bool {
+	if len(p.config.Steps) == 0 {
+		return len(p.config.States) == 0 && len(p.stateFiles) == 0
+	}
+	for _, step := range p.config.Steps {
+		if step.Name == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// treeSources returns the SLS files within a local directory that are uploaded.
+func (p *Provisioner) treeSources(dir string, trees []string, checkIncludes bool) []slsSource {
+	rules, err := readUploadRules(dir, p.config.Exclude, p.config.Include)
+	if err != nil {
+		return nil
+	}
+	files, err := dirFiles(dir, rules)
+	if err != nil {
+		return nil
+	}
+
+	var sources []slsSource
+	for _, f := range files {
+		if path.Ext(f.Path) != ".sls" {
+			continue
+		}
+		f.Name, _ = slsName(f.Path)
+		sources = append(sources, slsSource{slsFile: f, Trees: trees, CheckIncludes: checkIncludes})
+	}
+	return sources
+}
+
+func (p *Provisioner) validateSLSSource(s slsSource) []error {
+	content := s.Content
+	if content == "" {
+		data, err := os.ReadFile(s.Source)
+		if err != nil {
+			return []error{fmt.Errorf("validate_sls: %s: %s", s.Source, err)}
+		}
+		content = string(data)
+	} else {
+		content = renderInlineState(content)
+	}
+
+	renderers, ok := slsRenderers(content)
+	if !ok {
+		return nil
+	}
+	if renderers["jinja"] {
+		content = stripJinja(content)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		return []error{fmt.Errorf("validate_sls: %s: %s", s.Source, strings.TrimPrefix(err.Error(), "yaml: "))}
+	}
+	if !s.CheckIncludes || s.Name == "" {
+		return nil
+	}
+
+	var errs []error
+	for _, include := range slsIncludes(&doc) {
+		target, ok := resolveInclude(s.Name, path.Base(s.Path) == "init.sls", include.Value)
+		if !ok {
+			continue
+		}
+		if !p.includeExists(target, s.Trees) {
+			errs = append(errs, fmt.Errorf("validate_sls: %s: line %d: include %s not found", s.Source, include.Line, include.Value))
+		}
+	}
+	return errs
+}
+
+// slsRenderers returns the renderers declared by the shebang line of an SLS
+// file, or the default renderers, and whether the file can be validated as YAML.
+func slsRenderers(content string) (map[string]bool, bool) {
+	renderers := map[string]bool{"jinja": true, "yaml": true}
+	if !strings.HasPrefix(content, "#!") {
+		return renderers, true
+	}
+
+	line := strings.SplitN(content, "\n", 2)[0]
+	renderers = make(map[string]bool)
+	for _, r := range strings.Split(strings.TrimPrefix(line, "#!"), "|") {
+		r = strings.TrimSpace(r)
+		if !yamlRenderers[r] {
+			return nil, false
+		}
+		renderers[r] = true
+	}
+	return renderers, true
+}
+
+// stripJinja removes Jinja statements and comments from an SLS file and replaces
+// expressions with a placeholder, so that the remaining YAML can be parsed. Line
+// breaks are kept so that errors are reported on the correct line.
+func stripJinja(content string) string {
+	var b strings.Builder
+	for len(content) > 0 {
+		start := strings.Index(content, "{")
+		if start < 0 || start == len(content)-1 {
+			b.WriteString(content)
+			break
+		}
+		b.WriteString(content[:start])
+		content = content[start:]
+
+		var end string
+		switch content[1] {
+		case '%':
+			end = "%}"
+		case '#':
+			end = "#}"
+		case '{':
+			end = "}}"
+		default:
+			b.WriteByte('{')
+			content = content[1:]
+			continue
+		}
+
+		stop := strings.Index(content[2:], end)
+		block := content
+		if stop >= 0 {
+			block = content[:stop+4]
+		}
+		if end == "}}" {
+			b.WriteString(jinjaPlaceholder)
+		}
+		b.WriteString(strings.Repeat("\n", strings.Count(block, "\n")))
+		content = content[len(block):]
+	}
+
+	// Lines that only contained Jinja statements are left empty
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// slsIncludes returns the names of the states included by an SLS file.
+func slsIncludes(doc *yaml.Node) []*yaml.Node {
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	root := doc.Content[0]
+
+	var includes []*yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "include" || root.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, item := range root.Content[i+1].Content {
+			switch item.Kind {
+			case yaml.ScalarNode:
+				includes = append(includes, item)
+			case yaml.MappingNode:
+				// An include with options, such as a key or defaults
+				if len(item.Content) > 0 {
+					includes = append(includes, item.Content[0])
+				}
+			}
+		}
+	}
+	return includes
+}
+
+// resolveInclude returns the SLS name of an include, resolving relative
+// includes against the including state. It returns false if the include cannot
+// be checked, for example because it refers to another saltenv or uses Jinja.
+func resolveInclude(name string, isInit bool, include string) (string, bool) {
+	if include == "" || strings.Contains(include, jinjaPlaceholder) || strings.Contains(include, ":") {
+		return "", false
+	}
+	if !strings.HasPrefix(include, ".") {
+		return include, true
+	}
+
+	// A relative include starts from the package of the including state, and
+	// each additional leading dot moves up a level
+	parts := strings.Split(name, ".")
+	if !isInit {
+		parts = parts[:len(parts)-1]
+	}
+	rel := strings.TrimLeft(include, ".")
+	for up := len(include) - len(rel) - 1; up > 0; up-- {
+		if len(parts) == 0 {
+			return "", false
+		}
+		parts = parts[:len(parts)-1]
+	}
+	if rel != "" {
+		parts = append(parts, rel)
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return strings.Join(parts, "."), true
+}
+
+// includeExists reports whether an included state is one of the state files or
+// inline states, or can be found in the local state trees.
+func (p *Provisioner) includeExists(name string, trees []string) bool {
+	for _, f := range p.stateFiles {
+		if f.Name == name {
+			return true
+		}
+	}
+	for _, f := range p.inlineStates {
+		if f.Name == name {
+			return true
+		}
+	}
+	if len(trees) == 0 {
+		trees = p.stateTrees()
+	}
+	resolved, _ := resolveTreeStates(trees, []string{name})
+	return len(resolved) > 0
+}